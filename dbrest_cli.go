@@ -5,7 +5,9 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dbrest-io/dbrest/env"
 	"github.com/dbrest-io/dbrest/server"
@@ -104,6 +106,16 @@ var cliTokens = &g.CliSC{
 					Type:        "string",
 					Description: "The roles to attach the token to",
 				},
+				{
+					Name:        "attributes",
+					Type:        "string",
+					Description: "The token attributes for row filters, e.g. region=us,tenant=5",
+				},
+				{
+					Name:        "expires",
+					Type:        "string",
+					Description: "When the token expires, as a duration (e.g. 90d, 12h) or a date (e.g. 2025-12-31)",
+				},
 				{
 					Name:        "regenerate",
 					Type:        "bool",
@@ -147,6 +159,29 @@ var cliTokens = &g.CliSC{
 	ExecProcess: tokens,
 }
 
+var cliOpenAPI = &g.CliSC{
+	Name:        "openapi",
+	Description: "generate the OpenAPI spec of the tables visible to roles",
+	Flags: []g.Flag{
+		{
+			Name:        "roles",
+			Type:        "string",
+			Description: "The roles to generate the spec for (all tables by default)",
+		},
+		{
+			Name:        "url",
+			Type:        "string",
+			Description: "The server URL, default http://localhost:1323",
+		},
+		{
+			Name:        "output",
+			Type:        "string",
+			Description: "The file to write the spec to (stdout by default)",
+		},
+	},
+	ExecProcess: openapi,
+}
+
 func serve(c *g.CliSC) (ok bool, err error) {
 	project := state.DefaultProject()
 	if len(project.Connections) == 0 {
@@ -250,7 +285,28 @@ func tokens(c *g.CliSC) (ok bool, err error) {
 		oldToken, existing := project.Tokens[name]
 		if existing {
 			if !regenerate {
-				token.Token = oldToken.Token
+				token.Token, token.Hash, token.Salt = "", oldToken.Hash, oldToken.Salt
+			}
+			token.Attributes = oldToken.Attributes
+			token.ExpiresAt = oldToken.ExpiresAt
+		}
+
+		if expires := cast.ToString(c.Vals["expires"]); expires != "" {
+			expiresAt, err := parseExpiry(expires)
+			if err != nil {
+				return ok, g.Error(err, "invalid expiry")
+			}
+			token.ExpiresAt = &expiresAt
+		}
+
+		if attributes := cast.ToString(c.Vals["attributes"]); attributes != "" {
+			token.Attributes = map[string]string{}
+			for _, attribute := range strings.Split(attributes, ",") {
+				key, value, found := strings.Cut(attribute, "=")
+				if !found || strings.TrimSpace(key) == "" {
+					return ok, g.Error("invalid attribute `%s`, expected key=value", attribute)
+				}
+				token.Attributes[strings.TrimSpace(key)] = strings.TrimSpace(value)
 			}
 		}
 
@@ -265,6 +321,7 @@ func tokens(c *g.CliSC) (ok bool, err error) {
 				g.Info("Successfully added token `%s`", name)
 			}
 			g.Info("Token Value is: " + token.Token)
+			g.Warn("The token value is not stored and will not be shown again.")
 		} else {
 			g.Info("Successfully updated roles for token `%s`. The token value was unchanged. Use --regenerate to regenerate token value.", name)
 		}
@@ -290,11 +347,18 @@ func tokens(c *g.CliSC) (ok bool, err error) {
 		tokens := lo.Keys(project.Tokens)
 		sort.Strings(tokens)
 		T := table.NewWriter()
-		T.AppendHeader(table.Row{"Token Name", "Enabled", "Roles"})
+		T.AppendHeader(table.Row{"Token Name", "Enabled", "Roles", "Attributes", "Expires At"})
 		for _, name := range tokens {
 			token := project.Tokens[name]
+			attributes := lo.MapToSlice(token.Attributes, func(k, v string) string { return k + "=" + v })
+			sort.Strings(attributes)
+			expiresAt := ""
+			if token.ExpiresAt != nil {
+				expiresAt = token.ExpiresAt.Format(time.RFC3339)
+				expiresAt += lo.Ternary(token.Expired(), " (expired)", "")
+			}
 			T.AppendRow(
-				table.Row{name, cast.ToString(!token.Disabled), strings.Join(token.Roles, ",")},
+				table.Row{name, cast.ToString(!token.Disabled), strings.Join(token.Roles, ","), strings.Join(attributes, ","), expiresAt},
 			)
 		}
 		println(T.Render())
@@ -321,9 +385,29 @@ func tokens(c *g.CliSC) (ok bool, err error) {
 					data.Append([]any{roleName, connName, "AllowWrite", object})
 				}
 
+				for _, object := range grant.AllowInsert {
+					data.Append([]any{roleName, connName, "AllowInsert", object})
+				}
+
+				for _, object := range grant.AllowUpdate {
+					data.Append([]any{roleName, connName, "AllowUpdate", object})
+				}
+
+				for _, object := range grant.AllowUpsert {
+					data.Append([]any{roleName, connName, "AllowUpsert", object})
+				}
+
+				for _, object := range grant.AllowDelete {
+					data.Append([]any{roleName, connName, "AllowDelete", object})
+				}
+
 				if string(grant.AllowSQL) != "" {
 					data.Append([]any{roleName, connName, "AllowSQL", string(grant.AllowSQL)})
 				}
+
+				for object, predicate := range grant.RowFilter {
+					data.Append([]any{roleName, connName, "RowFilter", object + ": " + predicate})
+				}
 			}
 		}
 
@@ -335,6 +419,39 @@ func tokens(c *g.CliSC) (ok bool, err error) {
 	return
 }
 
+func openapi(c *g.CliSC) (ok bool, err error) {
+	ok = true
+	project := state.DefaultProject()
+
+	roles := state.AllowAllRoleMap
+	if val := cast.ToString(c.Vals["roles"]); val != "" {
+		if err = project.LoadRoles(true); err != nil {
+			return ok, g.Error(err, "could not load roles")
+		}
+		roles = project.GetRoleMap(strings.Split(val, ","))
+	}
+
+	url := cast.ToString(c.Vals["url"])
+	url = lo.Ternary(url == "", "http://localhost:1323", url)
+
+	spec, err := server.GenerateOpenAPI(server.NewOpenAPIRequest(project, roles), url)
+	if err != nil {
+		return ok, g.Error(err, "could not generate OpenAPI spec")
+	}
+
+	out := g.Pretty(spec)
+	if output := cast.ToString(c.Vals["output"]); output != "" {
+		if err = os.WriteFile(output, []byte(out), 0644); err != nil {
+			return ok, g.Error(err, "could not write %s", output)
+		}
+		g.Info("wrote OpenAPI spec to %s", output)
+	} else {
+		fmt.Println(out)
+	}
+
+	return ok, nil
+}
+
 func cliInit() int {
 	// init CLI
 	flaggy.SetName("dbrest")
@@ -347,6 +464,7 @@ func cliInit() int {
 	cliConns.Make().Add()
 	cliServe.Make().Add()
 	cliTokens.Make().Add()
+	cliOpenAPI.Make().Add()
 
 	for _, cli := range g.CliArr {
 		flaggy.AttachSubcommand(cli.Sc, 1)
@@ -416,3 +534,24 @@ func checkVersion() {
 		}
 	}
 }
+
+// parseExpiry parses a duration (e.g. 90d, 12h) or a date into an expiry time
+func parseExpiry(value string) (expiresAt time.Time, err error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().Add(time.Duration(n) * 24 * time.Hour), nil
+		}
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(duration), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if expiresAt, err = time.Parse(layout, value); err == nil {
+			return expiresAt, nil
+		}
+	}
+
+	return expiresAt, g.Error("could not parse `%s` as a duration or a date", value)
+}
@@ -7,12 +7,14 @@ toolchain go1.24.3
 require (
 	github.com/denisbrodbeck/machineid v1.0.1
 	github.com/flarco/g v0.1.146
+	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/integrii/flaggy v1.5.2
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/json-iterator/go v1.1.12
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0
 	github.com/labstack/echo/v5 v5.0.0-20230722203903-ec5b858dab61
+	github.com/prometheus/client_golang v1.20.5
 	github.com/samber/lo v1.39.0
 	github.com/slingdata-io/sling-cli v1.4.8
 	github.com/spf13/cast v1.7.1
@@ -119,7 +121,6 @@ require (
 	github.com/goccy/go-json v0.10.5 // indirect
 	github.com/goccy/go-yaml v1.16.0 // indirect
 	github.com/godbus/dbus v0.0.0-20190726142602-4481cbc300e2 // indirect
-	github.com/golang-jwt/jwt/v5 v5.2.2 // indirect
 	github.com/golang-sql/civil v0.0.0-20220223132316-b832511892a9 // indirect
 	github.com/golang-sql/sqlexp v0.1.0 // indirect
 	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
@@ -194,7 +195,6 @@ require (
 	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
-	github.com/prometheus/client_golang v1.20.5 // indirect
 	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/common v0.55.0 // indirect
 	github.com/prometheus/procfs v0.15.1 // indirect
@@ -273,8 +273,4 @@ require (
 	gorm.io/gorm v1.25.11 // indirect
 )
 
-replace github.com/slingdata-io/sling-cli => ../sling-cli
-
-replace github.com/flarco/g => ../g
-
 replace github.com/apache/iceberg-go => github.com/flarco/iceberg-go v0.0.0-20250611103821-67dfc1bc6720
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// auditMiddleware records every API call in the audit log, with the
+// values set by the handlers in the echo context (`request`, `query`,
+// `sql`, `rows` and `affected`)
+func auditMiddleware(audit *state.AuditLog, routeName string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			start := time.Now()
+			err = next(c)
+
+			event := state.AuditEvent{
+				Time:     start,
+				Route:    routeName,
+				Method:   c.Request().Method,
+				Path:     c.Request().URL.Path,
+				Status:   c.Response().Status,
+				Duration: time.Since(start).Milliseconds(),
+				RemoteIP: c.RealIP(),
+			}
+
+			if err != nil {
+				event.Status, event.Error = auditErrorStatus(err)
+			}
+
+			if req, ok := c.Get("request").(*Request); ok && req != nil {
+				event.RequestID = req.ID
+				event.Token = req.Token
+				event.Connection = req.Connection
+				event.Roles = lo.Keys(req.Roles)
+				sort.Strings(event.Roles)
+				if req.Project != nil {
+					event.Project = req.Project.ID
+				}
+				if req.Table != "" {
+					event.Table = lo.Ternary(req.dbTable.Name != "", req.dbTable.FullName(), req.Table)
+				}
+				event.SQL = req.Query
+			}
+
+			if query, ok := c.Get("query").(*state.Query); ok && query != nil {
+				event.QueryID = query.ID
+				event.SQL = lo.Ternary(query.Text != "", query.Text, event.SQL)
+				if query.Affected > 0 {
+					event.Affected = query.Affected
+				}
+				if event.Error == "" && query.Err != "" {
+					event.Error = query.Err
+				}
+			}
+
+			if sql := cast.ToString(c.Get("sql")); sql != "" {
+				event.SQL = sql
+			}
+
+			event.Rows = cast.ToInt64(c.Get("rows"))
+			if affected := cast.ToInt64(c.Get("affected")); affected > 0 {
+				event.Affected = affected
+			}
+
+			audit.Log(event)
+
+			return err
+		}
+	}
+}
+
+// auditErrorStatus returns the status and message of a handler error
+func auditErrorStatus(err error) (status int, msg string) {
+	var echoErr *echo.HTTPError
+	var gErr *g.HTTPError
+	message := func(m any) string {
+		if payload, ok := m.(map[string]any); ok {
+			return cast.ToString(payload["error"])
+		}
+		return cast.ToString(m)
+	}
+
+	switch {
+	case errors.As(err, &echoErr):
+		return echoErr.Code, message(echoErr.Message)
+	case errors.As(err, &gErr):
+		return gErr.Code, message(gErr.Message)
+	}
+	return http.StatusInternalServerError, err.Error()
+}
@@ -0,0 +1,529 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+// FilterOperator is a comparison operator used in a filter
+type FilterOperator string
+
+const (
+	FilterOpEq      FilterOperator = "eq"
+	FilterOpNeq     FilterOperator = "neq"
+	FilterOpGt      FilterOperator = "gt"
+	FilterOpGte     FilterOperator = "gte"
+	FilterOpLt      FilterOperator = "lt"
+	FilterOpLte     FilterOperator = "lte"
+	FilterOpLike    FilterOperator = "like"
+	FilterOpIlike   FilterOperator = "ilike"
+	FilterOpIn      FilterOperator = "in"
+	FilterOpIs      FilterOperator = "is"
+	FilterOpBetween FilterOperator = "between"
+)
+
+var filterOperators = []FilterOperator{
+	FilterOpEq, FilterOpNeq, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte,
+	FilterOpLike, FilterOpIlike, FilterOpIn, FilterOpIs, FilterOpBetween,
+}
+
+var filterComparators = map[FilterOperator]string{
+	FilterOpEq:  "=",
+	FilterOpNeq: "<>",
+	FilterOpGt:  ">",
+	FilterOpGte: ">=",
+	FilterOpLt:  "<",
+	FilterOpLte: "<=",
+}
+
+// filterReservedKeys are query params which are not column filters
+var filterReservedKeys = []string{"database", "limit", "flatten", "delimiter", "header", "datetime_format", "bulk"}
+
+// Filter is a condition parsed from the request query params.
+// A filter is either a column condition such as `country=eq.USA`,
+// or a group of conditions such as `or=(country.eq.USA,telcode.gt.100)`
+type Filter struct {
+	Column   string         `json:"column,omitempty"`
+	Operator FilterOperator `json:"operator,omitempty"`
+	Values   []string       `json:"values,omitempty"`
+	Negate   bool           `json:"negate,omitempty"`
+	Group    string         `json:"group,omitempty"` // `and` or `or`
+	Children []Filter       `json:"children,omitempty"`
+}
+
+// Filters is a list of filters, which are and-ed together
+type Filters []Filter
+
+// ParseFilters parses the query params into filters.
+// Keys starting with a `.` are options and are skipped.
+func ParseFilters(params url.Values) (filters Filters, err error) {
+	for k, vals := range params {
+		if strings.HasPrefix(k, ".") || g.In(k, filterReservedKeys...) {
+			continue
+		}
+
+		for _, v := range vals {
+			var filter Filter
+			switch key := strings.ToLower(k); key {
+			case "or", "and", "not.or", "not.and":
+				filter, err = parseFilterGroup(key + v)
+			default:
+				filter, err = parseFilterCondition(k, v)
+			}
+			if err != nil {
+				return nil, g.Error(err, "invalid filter: %s=%s", k, v)
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	// sort for deterministic SQL
+	return filters.sorted(), nil
+}
+
+// parseFilterCondition parses a condition for a column, such as
+// `eq.USA`, `not.in.(1,2,3)`, `is.null` or `between.(1,10)`.
+// A value without a known operator is an equality check.
+func parseFilterCondition(column, expr string) (filter Filter, err error) {
+	filter = Filter{Column: strings.TrimSpace(column)}
+	if filter.Column == "" {
+		return filter, g.Error("missing column name")
+	}
+
+	if strings.HasPrefix(expr, "not.") {
+		filter.Negate = true
+		expr = strings.TrimPrefix(expr, "not.")
+	}
+
+	opStr, value, found := strings.Cut(expr, ".")
+	operator := FilterOperator(strings.ToLower(opStr))
+	if !found || !lo.Contains(filterOperators, operator) {
+		if filter.Negate {
+			return filter, g.Error("missing operator after `not`")
+		}
+		filter.Operator = FilterOpEq
+		filter.Values = []string{expr}
+		return filter, nil
+	}
+
+	filter.Operator = operator
+	switch operator {
+	case FilterOpIn, FilterOpBetween:
+		if !(strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")) {
+			return filter, g.Error("values for `%s` must be enclosed in parentheses", operator)
+		}
+		filter.Values = splitFilterList(value[1 : len(value)-1])
+		if operator == FilterOpBetween && len(filter.Values) != 2 {
+			return filter, g.Error("`between` requires exactly 2 values")
+		} else if len(filter.Values) == 0 {
+			return filter, g.Error("`in` requires at least 1 value")
+		}
+	case FilterOpIs:
+		value = strings.ToLower(value)
+		if !g.In(value, "null", "true", "false") {
+			return filter, g.Error("`is` only accepts null, true or false")
+		}
+		filter.Values = []string{value}
+	default:
+		filter.Values = []string{value}
+	}
+
+	return filter, nil
+}
+
+// parseFilterGroup parses a group such as `or(col.eq.1,and(col2.gt.2,col3.is.null))`
+func parseFilterGroup(expr string) (filter Filter, err error) {
+	if strings.HasPrefix(expr, "not.") {
+		filter.Negate = true
+		expr = strings.TrimPrefix(expr, "not.")
+	}
+
+	open := strings.Index(expr, "(")
+	if open == -1 || !strings.HasSuffix(expr, ")") {
+		return filter, g.Error("group must be enclosed in parentheses")
+	}
+
+	filter.Group = strings.ToLower(expr[:open])
+	if !g.In(filter.Group, "and", "or") {
+		return filter, g.Error("invalid group: %s", filter.Group)
+	}
+
+	for _, item := range splitFilterList(expr[open+1 : len(expr)-1]) {
+		var child Filter
+		lower := strings.ToLower(item)
+		switch {
+		case strings.HasPrefix(lower, "and("), strings.HasPrefix(lower, "or("),
+			strings.HasPrefix(lower, "not.and("), strings.HasPrefix(lower, "not.or("):
+			child, err = parseFilterGroup(item)
+		default:
+			column, condition, found := strings.Cut(item, ".")
+			if !found {
+				return filter, g.Error("invalid condition: %s", item)
+			}
+			child, err = parseFilterCondition(column, condition)
+		}
+		if err != nil {
+			return filter, err
+		}
+		filter.Children = append(filter.Children, child)
+	}
+
+	if len(filter.Children) == 0 {
+		return filter, g.Error("group `%s` is empty", filter.Group)
+	}
+
+	return filter, nil
+}
+
+// splitFilterList splits on commas which are not nested in
+// parentheses or double quotes. Double quotes are removed.
+func splitFilterList(s string) (items []string) {
+	depth := 0
+	quoted := false
+	var item strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			if depth > 0 {
+				item.WriteRune(r) // nested, keep for the child parser
+			}
+			continue
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, item.String())
+			item.Reset()
+			continue
+		}
+		item.WriteRune(r)
+	}
+	if item.Len() > 0 || len(items) > 0 {
+		items = append(items, item.String())
+	}
+	return
+}
+
+func (fs Filters) sorted() Filters {
+	sort.SliceStable(fs, func(i, j int) bool {
+		return g.Marshal(fs[i]) < g.Marshal(fs[j])
+	})
+	return fs
+}
+
+// FilterRenderer renders filters into dialect-specific SQL
+// while validating the column names against the table columns.
+// Values are bound as parameters when the connection supports it,
+// otherwise they are rendered as escaped literals.
+type FilterRenderer struct {
+	Conn       database.Connection
+	Columns    iop.Columns
+	Args       []any
+	RowFilters []string          // row filter predicates, or-ed together
+	Attributes map[string]string // token attributes for the row filters
+	bind       bool
+}
+
+// NewFilterRenderer creates a new filter renderer
+func NewFilterRenderer(conn database.Connection, columns iop.Columns) *FilterRenderer {
+	return &FilterRenderer{
+		Conn:    conn,
+		Columns: columns,
+		Args:    []any{},
+		bind:    conn.Db() != nil, // connections not based on database/sql cannot bind
+	}
+}
+
+// Where renders the filters into a where clause expression
+func (fr *FilterRenderer) Where(filters Filters) (where string, err error) {
+	if len(filters) == 0 && len(fr.RowFilters) == 0 {
+		return "1=1", nil
+	}
+
+	exprs := []string{}
+	for _, filter := range filters {
+		expr, err := fr.render(filter)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	if len(fr.RowFilters) > 0 {
+		predicates := []string{}
+		for _, rowFilter := range fr.RowFilters {
+			predicate, err := fr.RowFilter(rowFilter)
+			if err != nil {
+				return "", err
+			}
+			predicates = append(predicates, "("+predicate+")")
+		}
+		exprs = append(exprs, "("+strings.Join(predicates, " or ")+")")
+	}
+
+	return strings.Join(exprs, " and "), nil
+}
+
+// tokenAttributeRegex matches `{token.<attribute>}`, with optional quotes
+var tokenAttributeRegex = regexp.MustCompile(`'\{token\.(\w+)\}'|\{token\.(\w+)\}`)
+
+// tokenAttributeNames returns the token attributes referenced in a row filter
+func tokenAttributeNames(predicate string) (names []string) {
+	for _, match := range tokenAttributeRegex.FindAllStringSubmatch(predicate, -1) {
+		names = append(names, match[1]+match[2])
+	}
+	return
+}
+
+// RowFilter renders a row filter predicate, replacing the token attributes
+// with bind values. A quoted attribute (`'{token.region}'`) is bound as a
+// string, an unquoted attribute as a number when numeric.
+func (fr *FilterRenderer) RowFilter(predicate string) (expr string, err error) {
+	expr = tokenAttributeRegex.ReplaceAllStringFunc(predicate, func(match string) string {
+		quoted := strings.HasPrefix(match, "'")
+		name := strings.TrimPrefix(strings.Trim(match, "'{}"), "token.")
+		value, ok := fr.Attributes[name]
+		if !ok {
+			err = g.Error("token attribute `%s` is not set", name)
+			return match
+		}
+
+		if !quoted {
+			if number, e := strconv.ParseInt(value, 10, 64); e == nil {
+				return fr.Arg(number)
+			} else if number, e := strconv.ParseFloat(value, 64); e == nil {
+				return fr.Arg(number)
+			}
+		}
+		return fr.Arg(value)
+	})
+	return
+}
+
+// Column returns the table column matching the name, case-insensitive
+func (fr *FilterRenderer) Column(name string) (col iop.Column, err error) {
+	name = strings.TrimSpace(name)
+	for _, c := range fr.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return c, nil
+		}
+	}
+	return col, g.Error("invalid column: %s", name)
+}
+
+// Quote returns the quoted column name if valid
+func (fr *FilterRenderer) Quote(name string) (string, error) {
+	col, err := fr.Column(name)
+	if err != nil {
+		return "", err
+	}
+	return fr.Conn.Quote(col.Name, false), nil
+}
+
+func (fr *FilterRenderer) render(filter Filter) (expr string, err error) {
+	if filter.Group != "" {
+		exprs := []string{}
+		for _, child := range filter.Children {
+			childExpr, err := fr.render(child)
+			if err != nil {
+				return "", err
+			}
+			exprs = append(exprs, childExpr)
+		}
+		expr = "(" + strings.Join(exprs, " "+filter.Group+" ") + ")"
+		return lo.Ternary(filter.Negate, "not "+expr, expr), nil
+	}
+
+	col, err := fr.Column(filter.Column)
+	if err != nil {
+		return "", err
+	}
+	field := fr.Conn.Quote(col.Name, false)
+
+	switch filter.Operator {
+	case FilterOpEq, FilterOpNeq, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		value, err := fr.Value(col, filter.Values[0])
+		if err != nil {
+			return "", err
+		}
+		expr = g.F("%s %s %s", field, filterComparators[filter.Operator], value)
+	case FilterOpLike, FilterOpIlike:
+		// `*` is accepted as a wildcard since `%` must be url encoded
+		pattern := strings.ReplaceAll(filter.Values[0], "*", "%")
+		value, err := fr.Value(iop.Column{Name: col.Name, Type: iop.StringType}, pattern)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case filter.Operator == FilterOpLike:
+			expr = g.F("%s like %s", field, value)
+		case fr.supportsIlike():
+			expr = g.F("%s ilike %s", field, value)
+		default:
+			expr = g.F("lower(%s) like lower(%s)", field, value)
+		}
+	case FilterOpIn:
+		values := []string{}
+		for _, v := range filter.Values {
+			value, err := fr.Value(col, v)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		expr = g.F("%s in (%s)", field, strings.Join(values, ", "))
+	case FilterOpBetween:
+		low, err := fr.Value(col, filter.Values[0])
+		if err != nil {
+			return "", err
+		}
+		high, err := fr.Value(col, filter.Values[1])
+		if err != nil {
+			return "", err
+		}
+		expr = g.F("%s between %s and %s", field, low, high)
+	case FilterOpIs:
+		switch filter.Values[0] {
+		case "null":
+			expr = g.F("%s is null", field)
+		default:
+			value, err := fr.Value(iop.Column{Name: col.Name, Type: iop.BoolType}, filter.Values[0])
+			if err != nil {
+				return "", err
+			}
+			expr = g.F("%s = %s", field, value)
+		}
+	default:
+		return "", g.Error("invalid operator: %s", filter.Operator)
+	}
+
+	return lo.Ternary(filter.Negate, "not ("+expr+")", expr), nil
+}
+
+// Value casts the value to the column type and returns its placeholder
+func (fr *FilterRenderer) Value(col iop.Column, value string) (string, error) {
+	val, err := castFilterValue(col, value)
+	if err != nil {
+		return "", g.Error(err, "invalid value for column %s: %s", col.Name, value)
+	}
+
+	return fr.Arg(val), nil
+}
+
+// BodyValue returns the placeholder for a value decoded from a JSON body.
+// Objects and arrays are stored as JSON text
+func (fr *FilterRenderer) BodyValue(col iop.Column, value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return fr.Arg(nil), nil
+	case map[string]any, []any:
+		return fr.Arg(g.Marshal(v)), nil
+	case fmt.Stringer: // json.Number
+		return fr.Value(col, v.String())
+	default:
+		return fr.Value(col, cast.ToString(v))
+	}
+}
+
+// Arg returns the bind placeholder for the value (and adds the arg),
+// or the escaped literal when binding is not supported
+func (fr *FilterRenderer) Arg(val any) string {
+	if !fr.bind || val == nil {
+		return fr.literal(val)
+	}
+
+	if v, ok := val.(decimalValue); ok {
+		val = string(v) // exact, converted by the database
+	}
+
+	fr.Args = append(fr.Args, val)
+	return fr.bindVar(len(fr.Args))
+}
+
+// bindVar renders the bind placeholder with the connection template
+// e.g. `?` for mysql, `$1` for postgres, `@p1` for sqlserver
+func (fr *FilterRenderer) bindVar(i int) string {
+	bindString := fr.Conn.Template().Variable["bind_string"]
+	if bindString == "" {
+		return "?"
+	}
+	return g.R(
+		bindString,
+		"i", cast.ToString(i),
+		"field", "p",
+		"n", cast.ToString(i),
+		"c", cast.ToString(i),
+	)
+}
+
+func (fr *FilterRenderer) literal(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		switch fr.Conn.GetType() {
+		case dbio.TypeDbSQLServer, dbio.TypeDbAzure, dbio.TypeDbAzureDWH, dbio.TypeDbOracle:
+			return lo.Ternary(v, "1", "0")
+		}
+		return lo.Ternary(v, "true", "false")
+	case int64, float64:
+		return cast.ToString(v)
+	case decimalValue:
+		return string(v)
+	default:
+		s := cast.ToString(v)
+		switch fr.Conn.GetType() {
+		case dbio.TypeDbMySQL, dbio.TypeDbMariaDB, dbio.TypeDbStarRocks, dbio.TypeDbBigQuery, dbio.TypeDbClickhouse:
+			s = strings.ReplaceAll(s, `\`, `\\`)
+		}
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+}
+
+func (fr *FilterRenderer) supportsIlike() bool {
+	switch fr.Conn.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbRedshift, dbio.TypeDbSnowflake, dbio.TypeDbDuckDb, dbio.TypeDbMotherDuck, dbio.TypeDbClickhouse:
+		return true
+	}
+	return false
+}
+
+// decimalValue is a validated decimal number, bound as a string
+// so that no precision is lost for NUMERIC columns
+type decimalValue string
+
+// decimalRegex matches a decimal number, e.g. `-12.50` or `1e-3`
+var decimalRegex = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// castFilterValue casts the string value to the column type
+// so that numbers and booleans are compared natively
+func castFilterValue(col iop.Column, value string) (val any, err error) {
+	switch {
+	case col.Type.IsInteger():
+		return cast.ToInt64E(value)
+	case col.Type.IsDecimal():
+		value = strings.TrimSpace(value)
+		if !decimalRegex.MatchString(value) {
+			return nil, g.Error("invalid decimal number: %s", value)
+		}
+		return decimalValue(value), nil
+	case col.Type.IsBool():
+		return cast.ToBoolE(value)
+	}
+	return value, nil
+}
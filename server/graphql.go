@@ -0,0 +1,640 @@
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/flarco/g"
+)
+
+// gqlDocument is a parsed GraphQL document (queries only)
+type gqlDocument struct {
+	Operations []gqlOperation
+	Fragments  map[string]gqlFragment
+}
+
+// gqlOperation is a query operation
+type gqlOperation struct {
+	Type       string // query, mutation or subscription
+	Name       string
+	Variables  []gqlVariableDef
+	Selections []gqlSelection
+}
+
+// gqlVariableDef is a variable definition, e.g. `$limit: Int = 10`
+type gqlVariableDef struct {
+	Name       string
+	Type       string
+	Default    any
+	HasDefault bool
+}
+
+// gqlFragment is a named fragment definition
+type gqlFragment struct {
+	Name       string
+	On         string
+	Selections []gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (`...Name`)
+// or an inline fragment (`... on Type { }`)
+type gqlSelection struct {
+	Alias      string
+	Name       string
+	Args       map[string]any
+	Directives []gqlDirective
+	Selections []gqlSelection
+	Spread     string // fragment spread name
+	Inline     bool   // inline fragment
+	On         string // type condition of the inline fragment
+}
+
+// gqlDirective is a directive such as `@include(if: $flag)`
+type gqlDirective struct {
+	Name string
+	Args map[string]any
+}
+
+// Key returns the response key of the field
+func (s gqlSelection) Key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// gqlVariable is a variable reference in a value
+type gqlVariable string
+
+// gqlEnum is an enum value in a value
+type gqlEnum string
+
+// gqlObject is a response object, which keeps the order of the keys
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	Key   string
+	Value any
+}
+
+// Set sets the value of the key
+func (o *gqlObject) Set(key string, value any) {
+	for i, entry := range *o {
+		if entry.Key == key {
+			(*o)[i].Value = value
+			return
+		}
+	}
+	*o = append(*o, gqlEntry{Key: key, Value: value})
+}
+
+// MarshalJSON marshals the object with its keys in order
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, entry := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlToken is a lexical token
+type gqlToken struct {
+	Kind  string // punct, name, int, float, string, eof
+	Value string
+	Pos   int
+}
+
+// gqlLex splits the GraphQL source into tokens. Commas, white space
+// and comments are ignored.
+func gqlLex(src string) (tokens []gqlToken, err error) {
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{Kind: "punct", Value: "...", Pos: i})
+			i += 3
+		case strings.ContainsRune("!$&()=:@[]{}|", rune(c)):
+			tokens = append(tokens, gqlToken{Kind: "punct", Value: string(c), Pos: i})
+			i++
+		case c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+			start := i
+			for i < len(src) && (src[i] == '_' || (src[i] >= 'A' && src[i] <= 'Z') || (src[i] >= 'a' && src[i] <= 'z') || (src[i] >= '0' && src[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{Kind: "name", Value: src[start:i], Pos: start})
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			kind := "int"
+			i++
+			for i < len(src) {
+				d := src[i]
+				if d >= '0' && d <= '9' {
+					i++
+				} else if d == '.' || d == 'e' || d == 'E' {
+					kind = "float"
+					i++
+					if (d == 'e' || d == 'E') && i < len(src) && (src[i] == '+' || src[i] == '-') {
+						i++
+					}
+				} else {
+					break
+				}
+			}
+			tokens = append(tokens, gqlToken{Kind: kind, Value: src[start:i], Pos: start})
+		case strings.HasPrefix(src[i:], `"""`):
+			end := strings.Index(src[i+3:], `"""`)
+			if end == -1 {
+				return nil, g.Error("unterminated block string at position %d", i)
+			}
+			value := strings.ReplaceAll(src[i+3:i+3+end], `\"""`, `"""`)
+			tokens = append(tokens, gqlToken{Kind: "string", Value: gqlBlockString(value), Pos: i})
+			i += end + 6
+		case c == '"':
+			value, n, err := gqlLexString(src[i:])
+			if err != nil {
+				return nil, g.Error(err, "invalid string at position %d", i)
+			}
+			tokens = append(tokens, gqlToken{Kind: "string", Value: value, Pos: i})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, g.Error("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, gqlToken{Kind: "eof", Pos: len(src)}), nil
+}
+
+// gqlLexString reads a quoted string, returns its value and length
+func gqlLexString(src string) (value string, n int, err error) {
+	var sb strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, g.Error("unterminated string")
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, g.Error("unterminated string")
+			}
+			i++
+			switch e := src[i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, g.Error("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(src[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, g.Error("invalid unicode escape")
+				}
+				sb.WriteRune(rune(code))
+				i += 4
+			default:
+				sb.WriteByte(e) // \" \\ \/
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, g.Error("unterminated string")
+}
+
+// gqlBlockString removes the common indentation of a block string
+func gqlBlockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent == -1 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// gqlParser is a recursive descent parser of GraphQL executable documents
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+// parseGraphQL parses the query document
+func parseGraphQL(src string) (doc gqlDocument, err error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return doc, err
+	}
+
+	p := &gqlParser{tokens: tokens}
+	doc.Fragments = map[string]gqlFragment{}
+	for p.peek().Kind != "eof" {
+		switch t := p.peek(); {
+		case t.Kind == "punct" && t.Value == "{":
+			selections, err := p.selectionSet()
+			if err != nil {
+				return doc, err
+			}
+			doc.Operations = append(doc.Operations, gqlOperation{Type: "query", Selections: selections})
+		case t.Kind == "name" && g.In(t.Value, "query", "mutation", "subscription"):
+			operation, err := p.operation()
+			if err != nil {
+				return doc, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		case t.Kind == "name" && t.Value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return doc, err
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			return doc, p.unexpected()
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return doc, g.Error("no operation in document")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.Kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *gqlParser) unexpected() error {
+	t := p.peek()
+	if t.Kind == "eof" {
+		return g.Error("unexpected end of document")
+	}
+	return g.Error("unexpected `%s` at position %d", t.Value, t.Pos)
+}
+
+// punct returns true and advances if the next token is the punctuator
+func (p *gqlParser) punct(value string) bool {
+	if t := p.peek(); t.Kind == "punct" && t.Value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(value string) error {
+	if !p.punct(value) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	if t := p.peek(); t.Kind == "name" {
+		p.pos++
+		return t.Value, nil
+	}
+	return "", p.unexpected()
+}
+
+func (p *gqlParser) operation() (op gqlOperation, err error) {
+	op.Type = p.next().Value
+	if p.peek().Kind == "name" {
+		op.Name = p.next().Value
+	}
+
+	if p.punct("(") {
+		for !p.punct(")") {
+			def := gqlVariableDef{}
+			if err = p.expect("$"); err != nil {
+				return
+			}
+			if def.Name, err = p.name(); err != nil {
+				return
+			}
+			if err = p.expect(":"); err != nil {
+				return
+			}
+			if def.Type, err = p.typeRef(); err != nil {
+				return
+			}
+			if p.punct("=") {
+				if def.Default, err = p.value(true); err != nil {
+					return
+				}
+				def.HasDefault = true
+			}
+			if _, err = p.directives(); err != nil {
+				return
+			}
+			op.Variables = append(op.Variables, def)
+		}
+	}
+
+	if _, err = p.directives(); err != nil {
+		return
+	}
+
+	op.Selections, err = p.selectionSet()
+	return
+}
+
+// typeRef parses a type reference such as `[Int!]!`, returned as text
+func (p *gqlParser) typeRef() (ref string, err error) {
+	if p.punct("[") {
+		item, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err = p.expect("]"); err != nil {
+			return "", err
+		}
+		ref = "[" + item + "]"
+	} else if ref, err = p.name(); err != nil {
+		return "", err
+	}
+
+	if p.punct("!") {
+		ref += "!"
+	}
+	return ref, nil
+}
+
+func (p *gqlParser) fragment() (fragment gqlFragment, err error) {
+	p.next() // fragment
+	if fragment.Name, err = p.name(); err != nil {
+		return
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return fragment, g.Error("expected `on` in fragment %s", fragment.Name)
+	}
+	if fragment.On, err = p.name(); err != nil {
+		return
+	}
+	if _, err = p.directives(); err != nil {
+		return
+	}
+	fragment.Selections, err = p.selectionSet()
+	return
+}
+
+func (p *gqlParser) selectionSet() (selections []gqlSelection, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+
+	for !p.punct("}") {
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+
+	if len(selections) == 0 {
+		return nil, g.Error("empty selection set")
+	}
+	return
+}
+
+func (p *gqlParser) selection() (s gqlSelection, err error) {
+	if p.punct("...") {
+		if t := p.peek(); t.Kind == "name" && t.Value != "on" {
+			s.Spread = p.next().Value
+			s.Directives, err = p.directives()
+			return
+		}
+
+		s.Inline = true
+		if t := p.peek(); t.Kind == "name" && t.Value == "on" {
+			p.next()
+			if s.On, err = p.name(); err != nil {
+				return
+			}
+		}
+		if s.Directives, err = p.directives(); err != nil {
+			return
+		}
+		s.Selections, err = p.selectionSet()
+		return
+	}
+
+	if s.Name, err = p.name(); err != nil {
+		return
+	}
+	if p.punct(":") {
+		s.Alias = s.Name
+		if s.Name, err = p.name(); err != nil {
+			return
+		}
+	}
+
+	if s.Args, err = p.arguments(); err != nil {
+		return
+	}
+	if s.Directives, err = p.directives(); err != nil {
+		return
+	}
+
+	if t := p.peek(); t.Kind == "punct" && t.Value == "{" {
+		s.Selections, err = p.selectionSet()
+	}
+	return
+}
+
+func (p *gqlParser) arguments() (args map[string]any, err error) {
+	args = map[string]any{}
+	if !p.punct("(") {
+		return
+	}
+
+	for !p.punct(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (p *gqlParser) directives() (directives []gqlDirective, err error) {
+	for p.punct("@") {
+		directive := gqlDirective{}
+		if directive.Name, err = p.name(); err != nil {
+			return
+		}
+		if directive.Args, err = p.arguments(); err != nil {
+			return
+		}
+		directives = append(directives, directive)
+	}
+	return
+}
+
+// value parses a value. Variables are not allowed in constant values.
+func (p *gqlParser) value(constant bool) (value any, err error) {
+	t := p.next()
+	switch {
+	case t.Kind == "punct" && t.Value == "$" && !constant:
+		name, err := p.name()
+		return gqlVariable(name), err
+	case t.Kind == "int":
+		return strconv.ParseInt(t.Value, 10, 64)
+	case t.Kind == "float":
+		return strconv.ParseFloat(t.Value, 64)
+	case t.Kind == "string":
+		return t.Value, nil
+	case t.Kind == "name":
+		switch t.Value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(t.Value), nil
+	case t.Kind == "punct" && t.Value == "[":
+		list := []any{}
+		for !p.punct("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case t.Kind == "punct" && t.Value == "{":
+		object := map[string]any{}
+		for !p.punct("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// Operation returns the operation to execute. The name is required
+// when the document has several operations.
+func (doc gqlDocument) Operation(name string) (operation gqlOperation, err error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return operation, g.Error("operationName is required when the document has several operations")
+		}
+		return doc.Operations[0], nil
+	}
+
+	for _, operation := range doc.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return operation, g.Error("operation %s not found", name)
+}
+
+// VariableValues returns the values of the operation variables,
+// with their defaults
+func (op gqlOperation) VariableValues(values map[string]any) (variables map[string]any, err error) {
+	variables = map[string]any{}
+	for _, def := range op.Variables {
+		value, ok := values[def.Name]
+		if !ok && def.HasDefault {
+			value, ok = gqlResolveValue(def.Default, nil), true
+		}
+		if (!ok || value == nil) && strings.HasSuffix(def.Type, "!") {
+			return nil, g.Error("variable $%s of type %s is required", def.Name, def.Type)
+		}
+		variables[def.Name] = value
+	}
+	return
+}
+
+// gqlResolveValue replaces the variables in the value
+func gqlResolveValue(value any, variables map[string]any) any {
+	switch v := value.(type) {
+	case gqlVariable:
+		return variables[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = gqlResolveValue(item, variables)
+		}
+		return list
+	case map[string]any:
+		object := map[string]any{}
+		for k, item := range v {
+			object[k] = gqlResolveValue(item, variables)
+		}
+		return object
+	}
+	return value
+}
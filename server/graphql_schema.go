@@ -0,0 +1,365 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// gqlTypeDef is a type of the schema, for the SDL and introspection
+type gqlTypeDef struct {
+	Kind        string // SCALAR, OBJECT, INPUT_OBJECT or ENUM
+	Name        string
+	Description string
+	Fields      []gqlFieldDef // fields, or input fields
+	EnumValues  []string
+}
+
+// gqlFieldDef is a field, argument or input field
+type gqlFieldDef struct {
+	Name        string
+	Description string
+	Type        string // type reference, e.g. `[main_place!]!`
+	Args        []gqlFieldDef
+}
+
+// gqlBuiltinScalars are the scalars not declared in the SDL
+var gqlBuiltinScalars = []string{"Boolean", "Float", "Int", "String"}
+
+// Types returns the types of the schema: the object type, filter and
+// order inputs of each table, the comparison inputs and the Query type
+func (s *gqlSchema) Types() (types []gqlTypeDef) {
+	for _, scalar := range gqlBuiltinScalars {
+		types = append(types, gqlTypeDef{Kind: "SCALAR", Name: scalar})
+	}
+	types = append(types, gqlTypeDef{
+		Kind:        "SCALAR",
+		Name:        "JSON",
+		Description: "JSON value",
+	})
+
+	types = append(types, gqlTypeDef{
+		Kind:        "ENUM",
+		Name:        "order_direction",
+		Description: "Sort direction, with the position of nulls",
+		EnumValues:  []string{"asc", "asc_nulls_first", "asc_nulls_last", "desc", "desc_nulls_first", "desc_nulls_last"},
+	})
+
+	for _, scalar := range []string{"Boolean", "Float", "Int", "JSON", "String"} {
+		comparison := gqlTypeDef{
+			Kind:        "INPUT_OBJECT",
+			Name:        scalar + "_comparison",
+			Description: "Conditions on a field of type " + scalar + ", and-ed together",
+		}
+		switch scalar {
+		case "Boolean":
+			comparison.Fields = append(comparison.Fields,
+				gqlFieldDef{Name: "eq", Type: scalar},
+				gqlFieldDef{Name: "neq", Type: scalar},
+			)
+		case "Float", "Int", "String":
+			for _, op := range []string{"eq", "neq", "gt", "gte", "lt", "lte"} {
+				comparison.Fields = append(comparison.Fields, gqlFieldDef{Name: op, Type: scalar})
+			}
+			comparison.Fields = append(comparison.Fields,
+				gqlFieldDef{Name: "in", Type: "[" + scalar + "!]"},
+				gqlFieldDef{Name: "nin", Type: "[" + scalar + "!]"},
+			)
+		}
+		if scalar == "String" {
+			comparison.Fields = append(comparison.Fields,
+				gqlFieldDef{Name: "like", Type: "String", Description: "Pattern with `%` or `*` wildcards"},
+				gqlFieldDef{Name: "ilike", Type: "String", Description: "Case-insensitive pattern"},
+			)
+		}
+		comparison.Fields = append(comparison.Fields, gqlFieldDef{Name: "is_null", Type: "Boolean"})
+		types = append(types, comparison)
+	}
+
+	query := gqlTypeDef{Kind: "OBJECT", Name: "Query"}
+	for _, name := range s.names {
+		t := s.Tables[name]
+		query.Fields = append(query.Fields, gqlFieldDef{
+			Name:        t.Name,
+			Description: g.F("Rows of %s (%d by default)", t.Table.FullName(), gqlDefaultLimit),
+			Type:        "[" + t.Name + "!]!",
+			Args:        t.listArgs(),
+		})
+		types = append(types, t.typeDefs()...)
+	}
+	types = append(types, query)
+
+	return types
+}
+
+// listArgs are the arguments of a list of rows
+func (t *gqlTable) listArgs() []gqlFieldDef {
+	return []gqlFieldDef{
+		{Name: "where", Type: t.Name + "_filter"},
+		{Name: "order_by", Type: "[" + t.Name + "_order_by!]"},
+		{Name: "limit", Type: "Int"},
+		{Name: "offset", Type: "Int"},
+	}
+}
+
+// typeDefs returns the object type of the table, and its filter
+// and order inputs
+func (t *gqlTable) typeDefs() []gqlTypeDef {
+	object := gqlTypeDef{Kind: "OBJECT", Name: t.Name, Description: "Row of " + t.Table.FullName()}
+	filter := gqlTypeDef{
+		Kind:        "INPUT_OBJECT",
+		Name:        t.Name + "_filter",
+		Description: "Conditions on the rows of " + t.Table.FullName() + ", and-ed together",
+		Fields: []gqlFieldDef{
+			{Name: "and", Type: "[" + t.Name + "_filter!]"},
+			{Name: "or", Type: "[" + t.Name + "_filter!]"},
+			{Name: "not", Type: t.Name + "_filter"},
+		},
+	}
+	orderBy := gqlTypeDef{
+		Kind:        "INPUT_OBJECT",
+		Name:        t.Name + "_order_by",
+		Description: "Sort field, one per item",
+	}
+
+	for _, name := range t.order {
+		if col, ok := t.Fields[name]; ok {
+			object.Fields = append(object.Fields, gqlFieldDef{Name: name, Type: gqlScalar(col), Description: col.DbType})
+			filter.Fields = append(filter.Fields, gqlFieldDef{Name: name, Type: gqlScalar(col) + "_comparison"})
+			orderBy.Fields = append(orderBy.Fields, gqlFieldDef{Name: name, Type: "order_direction"})
+			continue
+		}
+
+		relation := t.Relations[name]
+		if relation.List {
+			object.Fields = append(object.Fields, gqlFieldDef{
+				Name:        name,
+				Description: g.F("Rows of %s referencing this row (%s)", relation.Target.Table.FullName(), relation.TargetColumn),
+				Type:        "[" + relation.Target.Name + "!]!",
+				Args:        relation.Target.listArgs(),
+			})
+		} else {
+			object.Fields = append(object.Fields, gqlFieldDef{
+				Name:        name,
+				Description: g.F("Row of %s referenced by %s", relation.Target.Table.FullName(), relation.Column),
+				Type:        relation.Target.Name,
+			})
+		}
+	}
+
+	return []gqlTypeDef{object, filter, orderBy}
+}
+
+// SDL returns the schema definition language of the schema
+func (s *gqlSchema) SDL() string {
+	var sb strings.Builder
+	description := func(text, indent string) {
+		if text != "" {
+			sb.WriteString(indent + `"""` + strings.ReplaceAll(text, `"""`, `\"""`) + `"""` + "\n")
+		}
+	}
+
+	for _, typ := range s.Types() {
+		if lo.Contains(gqlBuiltinScalars, typ.Name) {
+			continue
+		}
+
+		description(typ.Description, "")
+		switch typ.Kind {
+		case "SCALAR":
+			sb.WriteString("scalar " + typ.Name + "\n\n")
+			continue
+		case "ENUM":
+			sb.WriteString("enum " + typ.Name + " {\n")
+			for _, value := range typ.EnumValues {
+				sb.WriteString("  " + value + "\n")
+			}
+			sb.WriteString("}\n\n")
+			continue
+		case "INPUT_OBJECT":
+			sb.WriteString("input " + typ.Name + " {\n")
+		default:
+			sb.WriteString("type " + typ.Name + " {\n")
+		}
+
+		for _, field := range typ.Fields {
+			description(field.Description, "  ")
+			sb.WriteString("  " + field.Name)
+			if len(field.Args) > 0 {
+				args := lo.Map(field.Args, func(arg gqlFieldDef, i int) string {
+					return arg.Name + ": " + arg.Type
+				})
+				sb.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			sb.WriteString(": " + field.Type + "\n")
+		}
+		sb.WriteString("}\n\n")
+	}
+
+	return strings.TrimSpace(sb.String()) + "\n"
+}
+
+// introspectionTypes returns the introspection objects (`__Type`) of the
+// types by name. Type references are resolved lazily, so that nested
+// selections can follow them.
+func (s *gqlSchema) introspectionTypes() (types map[string]map[string]any) {
+	types = map[string]map[string]any{}
+
+	var typeRef func(ref string) any
+	typeRef = func(ref string) any {
+		wrapper := func(kind string, ofType any) map[string]any {
+			return g.M("__typename", "__Type", "kind", kind, "name", nil, "ofType", ofType)
+		}
+		switch {
+		case strings.HasSuffix(ref, "!"):
+			return wrapper("NON_NULL", typeRef(strings.TrimSuffix(ref, "!")))
+		case strings.HasPrefix(ref, "["):
+			return wrapper("LIST", typeRef(strings.TrimSuffix(strings.TrimPrefix(ref, "["), "]")))
+		}
+		return func() any { return types[ref] }
+	}
+
+	inputValues := func(fields []gqlFieldDef) []any {
+		values := []any{}
+		for _, field := range fields {
+			values = append(values, g.M(
+				"__typename", "__InputValue",
+				"name", field.Name,
+				"description", lo.Ternary[any](field.Description == "", nil, field.Description),
+				"type", typeRef(field.Type),
+				"defaultValue", nil,
+				"isDeprecated", false,
+				"deprecationReason", nil,
+			))
+		}
+		return values
+	}
+
+	for _, typ := range s.Types() {
+		object := g.M(
+			"__typename", "__Type",
+			"kind", typ.Kind,
+			"name", typ.Name,
+			"description", lo.Ternary[any](typ.Description == "", nil, typ.Description),
+			"fields", nil,
+			"inputFields", nil,
+			"interfaces", nil,
+			"enumValues", nil,
+			"possibleTypes", nil,
+			"ofType", nil,
+			"specifiedByURL", nil,
+		)
+
+		switch typ.Kind {
+		case "OBJECT":
+			fields := []any{}
+			for _, field := range typ.Fields {
+				fields = append(fields, g.M(
+					"__typename", "__Field",
+					"name", field.Name,
+					"description", lo.Ternary[any](field.Description == "", nil, field.Description),
+					"args", inputValues(field.Args),
+					"type", typeRef(field.Type),
+					"isDeprecated", false,
+					"deprecationReason", nil,
+				))
+			}
+			object["fields"] = fields
+			object["interfaces"] = []any{}
+		case "INPUT_OBJECT":
+			object["inputFields"] = inputValues(typ.Fields)
+		case "ENUM":
+			object["enumValues"] = lo.Map(typ.EnumValues, func(value string, i int) any {
+				return g.M(
+					"__typename", "__EnumValue",
+					"name", value,
+					"description", nil,
+					"isDeprecated", false,
+					"deprecationReason", nil,
+				)
+			})
+		}
+
+		types[typ.Name] = object
+	}
+
+	return types
+}
+
+// Introspection returns the introspection object (`__Schema`) of the schema
+func (s *gqlSchema) Introspection() map[string]any {
+	types := s.introspectionTypes()
+	names := lo.Keys(types)
+	sort.Strings(names)
+
+	condition := g.M(
+		"__typename", "__InputValue",
+		"name", "if",
+		"description", nil,
+		"type", g.M("__typename", "__Type", "kind", "NON_NULL", "name", nil, "ofType", types["Boolean"]),
+		"defaultValue", nil,
+		"isDeprecated", false,
+		"deprecationReason", nil,
+	)
+	directive := func(name, description string) any {
+		return g.M(
+			"__typename", "__Directive",
+			"name", name,
+			"description", description,
+			"locations", []any{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+			"args", []any{condition},
+			"isRepeatable", false,
+		)
+	}
+
+	return g.M(
+		"__typename", "__Schema",
+		"description", nil,
+		"queryType", types["Query"],
+		"mutationType", nil,
+		"subscriptionType", nil,
+		"types", lo.Map(names, func(name string, i int) any { return types[name] }),
+		"directives", []any{
+			directive("include", "Includes the field when `if` is true"),
+			directive("skip", "Skips the field when `if` is true"),
+		},
+	)
+}
+
+// project returns the selection of an introspection value. Objects are
+// maps with their type in `__typename`, lazy values are functions.
+func (ex *gqlExecutor) project(value any, selections []gqlSelection, path []any) any {
+	if lazy, ok := value.(func() any); ok {
+		value = lazy()
+	}
+
+	switch v := value.(type) {
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = ex.project(item, selections, append(path[:len(path):len(path)], i))
+		}
+		return list
+	case map[string]any:
+		if v == nil {
+			return nil
+		}
+
+		fields, err := ex.collectFields(selections, cast.ToString(v["__typename"]))
+		if err != nil {
+			ex.addError(err, path)
+			return nil
+		}
+
+		object := gqlObject{}
+		for _, field := range fields {
+			object.Set(field.Key(), ex.project(v[field.Name], field.Selections, append(path[:len(path):len(path)], field.Key())))
+		}
+		return object
+	}
+
+	return value
+}
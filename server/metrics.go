@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cast"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dbrest_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	metricRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dbrest_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	metricQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dbrest_query_duration_seconds",
+		Help:    "Duration of queries (until the results are streamed) by connection and status.",
+		Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"connection", "status"})
+
+	metricRowsStreamed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dbrest_rows_streamed_total",
+		Help: "Number of rows returned by connection.",
+	}, []string{"connection"})
+
+	metricAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dbrest_auth_failures_total",
+		Help: "Number of failed token authentications by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		metricRequests,
+		metricRequestDuration,
+		metricQueryDuration,
+		metricRowsStreamed,
+		metricAuthFailures,
+		stateCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// metricsMiddleware records the request metrics of the route
+func metricsMiddleware(routeName string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			start := time.Now()
+			err = next(c)
+			elapsed := time.Since(start).Seconds()
+
+			status := c.Response().Status
+			if err != nil {
+				status, _ = auditErrorStatus(err)
+			}
+			statusS := cast.ToString(status)
+
+			metricRequests.WithLabelValues(routeName, c.Request().Method, statusS).Inc()
+			metricRequestDuration.WithLabelValues(routeName, c.Request().Method, statusS).Observe(elapsed)
+
+			if query, ok := c.Get("query").(*state.Query); ok && query != nil && status != http.StatusAccepted {
+				metricQueryDuration.WithLabelValues(query.Conn, string(query.Status)).Observe(elapsed)
+			}
+
+			if rows := cast.ToFloat64(c.Get("rows")); rows > 0 {
+				if req, ok := c.Get("request").(*Request); ok && req != nil {
+					metricRowsStreamed.WithLabelValues(req.Connection).Add(rows)
+				}
+			}
+
+			return err
+		}
+	}
+}
+
+// stateCollector collects the query counts and connection pool stats
+// when scraped
+type stateCollector struct{}
+
+var (
+	descQueries = prometheus.NewDesc(
+		"dbrest_queries", "Number of tracked queries by project and status (submitted queries are active).",
+		[]string{"project", "status"}, nil,
+	)
+	descPoolOpen = prometheus.NewDesc(
+		"dbrest_pool_open_connections", "Number of open connections of the pool.",
+		[]string{"project", "connection", "database"}, nil,
+	)
+	descPoolInUse = prometheus.NewDesc(
+		"dbrest_pool_in_use_connections", "Number of connections of the pool in use.",
+		[]string{"project", "connection", "database"}, nil,
+	)
+	descPoolIdle = prometheus.NewDesc(
+		"dbrest_pool_idle_connections", "Number of idle connections of the pool.",
+		[]string{"project", "connection", "database"}, nil,
+	)
+	descPoolWaitCount = prometheus.NewDesc(
+		"dbrest_pool_wait_count_total", "Number of connections waited for.",
+		[]string{"project", "connection", "database"}, nil,
+	)
+	descPoolWaitDuration = prometheus.NewDesc(
+		"dbrest_pool_wait_duration_seconds_total", "Time blocked waiting for a new connection.",
+		[]string{"project", "connection", "database"}, nil,
+	)
+)
+
+func (sc stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descQueries
+	ch <- descPoolOpen
+	ch <- descPoolInUse
+	ch <- descPoolIdle
+	ch <- descPoolWaitCount
+	ch <- descPoolWaitDuration
+}
+
+func (sc stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for project, counts := range state.QueryCounts() {
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(descQueries, prometheus.GaugeValue, float64(count), project, string(status))
+		}
+	}
+
+	for _, stat := range state.ConnectionStats() {
+		labels := []string{stat.Project, stat.Connection, stat.Database}
+		ch <- prometheus.MustNewConstMetric(descPoolOpen, prometheus.GaugeValue, float64(stat.Stats.OpenConnections), labels...)
+		ch <- prometheus.MustNewConstMetric(descPoolInUse, prometheus.GaugeValue, float64(stat.Stats.InUse), labels...)
+		ch <- prometheus.MustNewConstMetric(descPoolIdle, prometheus.GaugeValue, float64(stat.Stats.Idle), labels...)
+		ch <- prometheus.MustNewConstMetric(descPoolWaitCount, prometheus.CounterValue, float64(stat.Stats.WaitCount), labels...)
+		ch <- prometheus.MustNewConstMetric(descPoolWaitDuration, prometheus.CounterValue, stat.Stats.WaitDuration.Seconds(), labels...)
+	}
+}
+
+var metricsHandler = echo.WrapHandler(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+// getMetrics serves the Prometheus metrics. If DBREST_METRICS_TOKEN
+// is set, it must be provided as bearer token.
+func getMetrics(c echo.Context) (err error) {
+	if token := os.Getenv("DBREST_METRICS_TOKEN"); token != "" && !matchBearerToken(c, token) {
+		return g.ErrJSON(http.StatusUnauthorized, g.Error("invalid metrics token"))
+	}
+	return metricsHandler(c)
+}
+
+// matchBearerToken returns true if the request has the bearer token,
+// compared in constant time
+func matchBearerToken(c echo.Context, token string) bool {
+	value := strings.TrimSpace(strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer "))
+	return subtle.ConstantTimeCompare([]byte(value), []byte(token)) == 1
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+)
+
+func getOpenAPI(c echo.Context) (err error) {
+	req := NewRequest(c)
+
+	if err = req.Validate(); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	}
+
+	scheme := lo.Ternary(c.Request().TLS != nil, "https", "http")
+	spec, err := GenerateOpenAPI(req, scheme+"://"+c.Request().Host)
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not generate OpenAPI spec")
+	}
+
+	return c.JSON(http.StatusOK, spec)
+}
+
+// NewOpenAPIRequest returns a request for GenerateOpenAPI outside of
+// the server, with the permissions of the roles
+func NewOpenAPIRequest(project *state.Project, roles state.RoleMap) *Request {
+	return &Request{Project: project, Roles: roles, Header: http.Header{}}
+}
+
+// GenerateOpenAPI generates the OpenAPI 3 spec of the tables visible
+// to the request roles, with a path per table and the typed row schemas
+func GenerateOpenAPI(req *Request, serverURL string) (spec map[string]any, err error) {
+	paths := g.M()
+	schemas := g.M()
+
+	connNames := lo.Filter(lo.Keys(req.Project.Connections), func(name string, i int) bool {
+		return req.Roles.HasAccess(name)
+	})
+	sort.Strings(connNames)
+
+	for _, connName := range connNames {
+		connReq, err := req.WithConnection(connName)
+		if err != nil {
+			return nil, err
+		}
+
+		tables, err := getVisibleTables(connReq)
+		if err != nil {
+			return nil, g.Error(err, "could not get tables of %s", connName)
+		}
+
+		for _, table := range tables {
+			name := strings.Join([]string{connName, table.Schema, table.Name}, ".")
+			path := "/" + strings.Join([]string{connName, table.Schema, table.Name}, "/")
+
+			item := openAPIPathItem(connReq, table, name)
+			if len(item) == 0 {
+				continue
+			}
+			paths[path] = item
+
+			if columns := connReq.VisibleColumns(table, table.Columns); len(columns) > 0 {
+				schemas[name] = openAPIRowSchema(columns, false)
+				schemas[name+".result"] = openAPIRowSchema(connReq.ReadableColumns(table, table.Columns), true)
+			}
+		}
+	}
+
+	schemas["affected"] = g.M(
+		"type", "object",
+		"properties", g.M("affected", g.M("type", "integer", "format", "int64")),
+	)
+
+	spec = g.M(
+		"openapi", "3.0.3",
+		"info", g.M(
+			"title", "dbREST",
+			"version", state.Version,
+			"description", "Tables visible to the token. See https://docs.dbrest.io",
+		),
+		"servers", []any{g.M("url", serverURL)},
+		"security", []any{g.M("token", []string{})},
+		"paths", paths,
+		"components", g.M(
+			"schemas", schemas,
+			"securitySchemes", g.M(
+				"token", g.M(
+					"type", "apiKey",
+					"in", "header",
+					"name", "Authorization",
+					"description", "Issued token, or signed JWT with `Bearer ` prefix",
+				),
+			),
+		),
+	)
+
+	return spec, nil
+}
+
+// getVisibleTables returns the tables (with columns) accessible to the
+// request on its connection, sorted by name
+func getVisibleTables(req *Request) (tables []database.Table, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		schemata, err := c.GetSchemata(database.SchemataLevelColumn, "")
+		if err != nil {
+			err = g.Error(err, "could not get columns")
+			return
+		}
+
+		for _, table := range schemata.Tables() {
+			if req.CanAccess(table) {
+				tables = append(tables, table)
+			}
+		}
+		return
+	}
+
+	if _, err = ProcessRequest(req, rf); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tables, func(i, j int) bool { return tables[i].FullName() < tables[j].FullName() })
+	return tables, nil
+}
+
+// openAPIPathItem returns the operations allowed on the table
+func openAPIPathItem(req *Request, table database.Table, name string) (item map[string]any) {
+	item = g.M()
+	ref := func(schema string) map[string]any {
+		return g.M("$ref", "#/components/schemas/"+schema)
+	}
+	content := func(schema map[string]any) map[string]any {
+		return g.M("application/json", g.M("schema", schema))
+	}
+	affected := g.M("200", g.M("description", "Number of affected rows", "content", content(ref("affected"))))
+	rows := g.M("type", "array", "items", ref(name+".result"))
+	body := func(op state.Operation, array bool) map[string]any {
+		schema := openAPIRowSchema(req.WritableColumns(op, table, table.Columns), false)
+		if array {
+			schema = g.M("type", "array", "items", schema)
+		}
+		return g.M("required", true, "content", content(schema))
+	}
+
+	withFilters := func(params ...any) []any {
+		return append(openAPIFilterParams(req.ReadableColumns(table, table.Columns)), params...)
+	}
+	param := func(name, description string, schema map[string]any) map[string]any {
+		return g.M("name", name, "in", "query", "required", false, "description", description, "schema", schema)
+	}
+	str, integer := g.M("type", "string"), g.M("type", "integer")
+	all := param(".all", "Required to apply on all rows when there are no filters", g.M("type", "boolean"))
+
+	if req.Can(state.OperationSelect, table) {
+		item["get"] = g.M(
+			"operationId", "select."+name,
+			"summary", "Select rows of "+table.FullName(),
+			"parameters", withFilters(
+				param(".columns", "Comma-separated columns to select", str),
+				param(".limit", "Maximum number of rows (500 by default, 100 per page with `.cursor`)", integer),
+				param(".offset", "Number of rows to skip", integer),
+				param(".order", "Sort order, e.g. `country.desc.nullslast,id`", str),
+				param(".cursor", "Keyset cursor, from the `X-Request-Cursor` header of the previous page", str),
+			),
+			"responses", g.M("200", g.M(
+				"description", "Rows (values are encoded as strings, the format indicates the column type)",
+				"content", g.M(
+					"application/json", g.M("schema", rows),
+					"application/jsonlines", g.M("schema", str),
+					"text/csv", g.M("schema", str),
+				),
+			)),
+		)
+	}
+
+	if req.Can(state.OperationInsert, table) {
+		item["post"] = g.M(
+			"operationId", "insert."+name,
+			"summary", "Insert rows into "+table.FullName(),
+			"requestBody", body(state.OperationInsert, true),
+			"responses", affected,
+		)
+	}
+
+	if req.Can(state.OperationUpsert, table) {
+		item["put"] = g.M(
+			"operationId", "upsert."+name,
+			"summary", "Upsert rows into "+table.FullName(),
+			"parameters", []any{param(".on_conflict", "Comma-separated key columns (primary key by default)", str)},
+			"requestBody", body(state.OperationUpsert, true),
+			"responses", affected,
+		)
+	}
+
+	if req.Can(state.OperationUpdate, table) {
+		item["patch"] = g.M(
+			"operationId", "update."+name,
+			"summary", "Update the filtered rows of "+table.FullName(),
+			"parameters", withFilters(all),
+			"requestBody", body(state.OperationUpdate, false),
+			"responses", affected,
+		)
+	}
+
+	if req.Can(state.OperationDelete, table) {
+		item["delete"] = g.M(
+			"operationId", "delete."+name,
+			"summary", "Delete the filtered rows of "+table.FullName(),
+			"parameters", withFilters(all),
+			"responses", affected,
+		)
+	}
+
+	return item
+}
+
+// openAPIFilterParams returns a filter query parameter per column
+func openAPIFilterParams(columns iop.Columns) (params []any) {
+	for _, col := range columns {
+		params = append(params, g.M(
+			"name", col.Name,
+			"in", "query",
+			"required", false,
+			"description", "Filter, e.g. `eq.value`, `gt.5`, `in.(a,b)`, `is.null`, `not.like.a*`",
+			"schema", g.M("type", "string"),
+		))
+	}
+	for _, group := range []string{"or", "and"} {
+		params = append(params, g.M(
+			"name", group,
+			"in", "query",
+			"required", false,
+			"description", "Filter group, e.g. `(city.ilike.*city,id.gte.5)`",
+			"schema", g.M("type", "string"),
+		))
+	}
+	return
+}
+
+// openAPIRowSchema returns the object schema of the columns. When
+// encoded, the values are strings with the column type as format.
+func openAPIRowSchema(columns iop.Columns, encoded bool) map[string]any {
+	properties := g.M()
+	for _, col := range columns {
+		schema := openAPIColumnSchema(col)
+		if encoded && schema["type"] != "string" {
+			format := lo.Ternary(schema["format"] != nil, schema["format"], schema["type"])
+			schema = g.M("type", "string")
+			if format != nil {
+				schema["format"] = format
+			}
+		}
+		schema["nullable"] = true
+		if col.DbType != "" {
+			schema["x-db-type"] = col.DbType
+		}
+		properties[col.Name] = schema
+	}
+	return g.M("type", "object", "properties", properties)
+}
+
+// openAPIColumnSchema returns the schema of the column type
+func openAPIColumnSchema(col iop.Column) map[string]any {
+	switch {
+	case col.Type.IsInteger():
+		return g.M("type", "integer", "format", "int64")
+	case col.Type.IsDecimal():
+		return g.M("type", "number", "format", "double")
+	case col.Type.IsBool():
+		return g.M("type", "boolean")
+	case col.Type == iop.DateType:
+		return g.M("type", "string", "format", "date")
+	case col.Type.IsDatetime():
+		return g.M("type", "string", "format", "date-time")
+	case col.Type == iop.JsonType:
+		return g.M()
+	}
+	return g.M("type", "string")
+}
@@ -30,22 +30,35 @@ type Request struct {
 	Procedure  string `json:"procedure" query:"procedure"`
 	Data       any    `json:"data" query:"data"`
 
-	Project     *state.Project        `json:"-" query:"-"`
-	conn        connection.Connection `json:"-" query:"-"`
-	Header      http.Header           `json:"-" query:"-"`
-	dbTable     database.Table        `json:"-" query:"-"`
-	Roles       state.RoleMap         `json:"-" query:"-"`
-	Permissions state.Permissions     `json:"-" query:"-"`
-	echoCtx     echo.Context          `json:"-" query:"-"`
+	Project     *state.Project          `json:"-" query:"-"`
+	args        []any                   `json:"-" query:"-"` // bind values for Query
+	columns     iop.Columns             `json:"-" query:"-"` // selected columns for Query
+	tableSelect *TableSelect            `json:"-" query:"-"` // for keyset paging
+	readOnly    bool                    `json:"-" query:"-"` // only_select, for Query
+	savedQuery  string                  `json:"-" query:"-"` // saved query name, for Query
+	conn        connection.Connection   `json:"-" query:"-"`
+	Header      http.Header             `json:"-" query:"-"`
+	dbTable     database.Table          `json:"-" query:"-"`
+	Token       string                  `json:"-" query:"-"` // token name, for the audit log
+	Roles       state.RoleMap           `json:"-" query:"-"`
+	Permissions state.Permissions       `json:"-" query:"-"`
+	ColumnPerms state.ColumnPermissions `json:"-" query:"-"`
+	Attributes  map[string]string       `json:"-" query:"-"` // token attributes, for row filters
+	echoCtx     echo.Context            `json:"-" query:"-"`
 }
 
-func NewRequest(c echo.Context) Request {
+// NewRequest parses the request of the echo context, and resolves the
+// token permissions. The request is set in the context for the middleware,
+// so the changes of the handler (e.g. the query) are audited.
+func NewRequest(c echo.Context) *Request {
 
-	req := Request{
+	req := &Request{
 		ID:          c.PathParam("id"),
+		Name:        c.PathParam("name"),
 		Connection:  strings.ToLower(c.PathParam("connection")),
 		Schema:      c.PathParam("schema"),
 		Table:       c.PathParam("table"),
+		Procedure:   c.PathParam("procedure"),
 		Database:    c.QueryParam("database"),
 		echoCtx:     c,
 		Header:      c.Request().Header,
@@ -54,7 +67,7 @@ func NewRequest(c echo.Context) Request {
 	}
 
 	// set for middleware
-	defer func() { c.Set("request", &req) }()
+	c.Set("request", req)
 
 	req.ID = lo.Ternary(req.ID == "", c.QueryParam("id"), req.ID)
 	req.Schema = lo.Ternary(req.Schema == "", c.QueryParam("schema"), req.Schema)
@@ -87,69 +100,122 @@ func NewRequest(c echo.Context) Request {
 	if req.Project.NoRestriction {
 		req.Roles = state.AllowAllRoleMap
 		req.Permissions = state.Permissions{
-			"*": state.PermissionReadWrite, // read/write access
+			"*": state.PermissionReadWrite | state.PermissionExecute, // read/write/execute access
 		}
 	} else if authToken := c.Request().Header.Get("Authorization"); authToken != "" {
 		// token -> roles -> grants
-		req.Project.LoadTokens(false) // load tokens, do not force, cached & throttled
-		token, ok := req.Project.ResolveToken(authToken)
-		if ok && !token.Disabled {
+		authToken = strings.TrimSpace(authToken)
+		if len(authToken) > 7 && strings.EqualFold(authToken[:7], "bearer ") {
+			authToken = strings.TrimSpace(authToken[7:])
+		}
+
+		var token state.Token
+		var ok bool
+		if req.Project.JWT != nil && state.IsJWT(authToken) {
+			token, err = req.Project.ResolveJWT(authToken)
+			if err != nil {
+				g.Debug("could not resolve JWT: %s", err.Error())
+				metricAuthFailures.WithLabelValues("invalid_jwt").Inc()
+			}
+			ok = err == nil
+		} else {
+			req.Project.LoadTokens(false) // load tokens, do not force, cached & throttled
+			if token, ok = req.Project.ResolveToken(authToken); !ok {
+				metricAuthFailures.WithLabelValues("invalid_token").Inc()
+			}
+		}
+
+		if ok && !token.Valid() {
+			metricAuthFailures.WithLabelValues(lo.Ternary(token.Expired(), "expired", "disabled")).Inc()
+		} else if ok {
 			req.Project.LoadRoles(false) // load roles, do not force, cached & throttled
 			req.Roles = req.Project.GetRoleMap(token.Roles)
 			req.Permissions = req.Roles.GetPermissions(conn)
+			req.ColumnPerms = req.Roles.GetColumnPermissions(conn)
+			req.Attributes = token.Attributes
+			req.Token = token.Name
 		}
 	}
 
 	return req
 }
 
-func (r *Request) CanRead(table database.Table) bool {
-	if p, ok := r.Permissions["*"]; ok {
-		if p.CanRead() {
-			return true
-		}
-	}
-	ts := r.Project.SchemaAll(r.Connection, table.Schema)
-	if p, ok := r.Permissions[ts.FullName()]; ok {
-		if p.CanRead() {
-			return true
-		}
+// WithConnection returns a copy of the request for the connection,
+// with the permissions of the roles on that connection
+func (r *Request) WithConnection(connName string) (req *Request, err error) {
+	copied := *r
+	req = &copied
+	req.Connection = strings.ToLower(connName)
+	req.conn, err = req.Project.GetConnObject(req.Connection, "")
+	if err != nil {
+		return req, g.Error(err, "could not get connection %s", connName)
 	}
 
-	if p, ok := r.Permissions[table.FullName()]; ok {
-		if p.CanRead() {
-			return true
-		}
-	}
+	req.Permissions = req.Roles.GetPermissions(req.conn)
+	req.ColumnPerms = req.Roles.GetColumnPermissions(req.conn)
+	return req, nil
+}
 
-	return false
+// Can returns true if the operation is allowed on the table
+func (r *Request) Can(op state.Operation, table database.Table) bool {
+	return r.Permissions.Get(table).Can(op)
 }
 
-func (r *Request) URL() *url.URL {
-	return r.echoCtx.Request().URL
+func (r *Request) CanRead(table database.Table) bool {
+	return r.Can(state.OperationSelect, table)
 }
 
-func (r *Request) CanWrite(table database.Table) bool {
-	if p, ok := r.Permissions["*"]; ok {
-		if p.CanWrite() {
-			return true
-		}
+// CanAccess returns true if any table operation is allowed on the table
+func (r *Request) CanAccess(table database.Table) bool {
+	return r.Permissions.Get(table)&state.PermissionReadWrite != state.PermissionNone
+}
+
+// ReadableColumns returns the table columns which can be read
+func (r *Request) ReadableColumns(table database.Table, columns iop.Columns) iop.Columns {
+	return r.WritableColumns(state.OperationSelect, table, columns)
+}
+
+// WritableColumns returns the table columns which can be written
+// (or read, for select) with the operation
+func (r *Request) WritableColumns(op state.Operation, table database.Table, columns iop.Columns) iop.Columns {
+	if !r.Can(op, table) {
+		return iop.Columns{}
 	}
+	objects := r.Permissions.Objects(table)
+	return lo.Filter(columns, func(col iop.Column, i int) bool {
+		return r.ColumnPerms[op].Allows(objects, col.Name)
+	})
+}
 
-	ts := r.Project.SchemaAll(r.Connection, table.Schema)
-	if p, ok := r.Permissions[ts.FullName()]; ok {
-		if p.CanWrite() {
-			return true
-		}
+// VisibleColumns returns the table columns which can be used
+// with any of the operations
+func (r *Request) VisibleColumns(table database.Table, columns iop.Columns) iop.Columns {
+	visible := iop.Columns{}
+	for _, op := range state.Operations {
+		visible = append(visible, r.WritableColumns(op, table, columns)...)
 	}
+	return lo.Filter(columns, func(col iop.Column, i int) bool {
+		return visible.GetColumn(col.Name) != nil
+	})
+}
 
-	if p, ok := r.Permissions[table.FullName()]; ok {
-		if p.CanWrite() {
-			return true
+// RowFilters returns the row filter predicates of the roles for the table
+// and operation. No predicates means the rows are unrestricted.
+// Errors if a predicate references a token attribute which is not set.
+func (r *Request) RowFilters(op state.Operation, table database.Table) (predicates []string, err error) {
+	predicates = r.Roles.GetRowFilters(r.conn, table, op)
+	for _, predicate := range predicates {
+		for _, name := range tokenAttributeNames(predicate) {
+			if _, ok := r.Attributes[name]; !ok {
+				return nil, g.Error("token attribute `%s` is required for table %s", name, table.FullName())
+			}
 		}
 	}
+	return
+}
 
-	return false
+func (r *Request) URL() *url.URL {
+	return r.echoCtx.Request().URL
 }
 
 func (req *Request) GetDatastream() (ds *iop.Datastream, err error) {
@@ -279,7 +345,7 @@ func (r *Request) Validate(checks ...requestCheck) (err error) {
 		case reqCheckTable:
 			if cast.ToString(r.Table) == "" {
 				eG.Add(g.Error("missing request value for: table"))
-			} else if !(r.CanRead(r.dbTable) || r.CanWrite(r.dbTable)) {
+			} else if !r.CanAccess(r.dbTable) {
 				eG.Add(g.Error("forbidden access for: table"))
 			}
 		case reqCheckID:
@@ -310,10 +376,10 @@ func (r *Request) Validate(checks ...requestCheck) (err error) {
 }
 
 // ReqFunction is the request function type
-type ReqFunction func(c database.Connection, req Request) (iop.Dataset, error)
+type ReqFunction func(c database.Connection, req *Request) (iop.Dataset, error)
 
 // ProcessRequest processes the request with the given function
-func ProcessRequest(req Request, reqFunc ReqFunction) (data iop.Dataset, err error) {
+func ProcessRequest(req *Request, reqFunc ReqFunction) (data iop.Dataset, err error) {
 	c, err := req.Project.GetConnInstance(req.Connection, req.Database)
 	if err != nil {
 		err = g.Error(err, "could not get conn %s", req.Connection)
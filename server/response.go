@@ -15,7 +15,7 @@ import (
 )
 
 type Response struct {
-	Request Request         `json:"-"`
+	Request *Request        `json:"-"`
 	Error   string          `json:"error,omitempty"`
 	Payload map[string]any  `json:"-"`
 	Status  int             `json:"-"`
@@ -25,7 +25,7 @@ type Response struct {
 	Header  http.Header     `json:"-" query:"-"`
 }
 
-func NewResponse(req Request) Response {
+func NewResponse(req *Request) Response {
 	resp := Response{
 		Request: req,
 		ec:      req.echoCtx,
@@ -33,7 +33,7 @@ func NewResponse(req Request) Response {
 		Header:  req.echoCtx.Response().Header(),
 	}
 	resp.Header.Set("X-Request-ID", req.ID)
-	resp.Header.Set("Access-Control-Expose-Headers", "X-Request-ID, X-Request-Columns, X-Request-Status, X-Request-Continue, X-Project-ID")
+	resp.Header.Set("Access-Control-Expose-Headers", "X-Request-ID, X-Request-Columns, X-Request-Status, X-Request-Continue, X-Request-Cursor, X-Project-ID, Preference-Applied")
 	return resp
 }
 
@@ -49,6 +49,10 @@ func (r *Response) MakeStreaming() (err error) {
 	respW := r.ec.Response().Writer
 	var pushRow func(row []interface{})
 
+	// for middleware
+	rows := int64(0)
+	defer func() { r.ec.Set("rows", rows) }()
+
 	fields := r.ds.Columns.Names()
 	acceptType := strings.ToLower(r.ec.Request().Header.Get(echo.HeaderAccept))
 
@@ -94,6 +98,7 @@ func (r *Response) MakeStreaming() (err error) {
 			r.ds.Context.Cancel()
 			g.LogError(g.Error(err, "could not encode json payload"))
 		}
+		rows = int64(len(data.Rows))
 		// convert all values to string since JS can truncate int values
 		// above Number.MAX_SAFE_INTEGER
 		out, _ := g.JSONMarshal(StringRecords(&data))
@@ -133,6 +138,7 @@ func (r *Response) MakeStreaming() (err error) {
 		default:
 			pushRow(row)
 			r.ec.Response().Flush()
+			rows++
 		}
 	}
 
@@ -141,6 +147,15 @@ func (r *Response) MakeStreaming() (err error) {
 
 func (r *Response) Make() (err error) {
 
+	// for middleware
+	defer func() {
+		if affected, ok := r.Payload["affected"]; ok {
+			r.ec.Set("affected", affected)
+		} else {
+			r.ec.Set("rows", len(r.data.Rows))
+		}
+	}()
+
 	if r.Payload != nil {
 		r.Header.Set("Content-Type", "application/json")
 		return r.ec.JSON(r.Status, r.Payload)
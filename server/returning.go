@@ -0,0 +1,182 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+)
+
+// maxBindValues is the maximum number of bind values per statement.
+// SQL Server accepts up to 2100 parameters
+const maxBindValues = 2000
+
+// ReturnRepresentation returns true when the client asked
+// for the written rows with header `Prefer: return=representation`
+func (r *Request) ReturnRepresentation() bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "return=representation") {
+			return true
+		}
+	}
+	return false
+}
+
+// returningClauses returns the clauses to get the written rows back for a
+// statement (`insert` or `update`). The output clause is placed before the
+// values / where clause (SQL Server), the returning clause at the end.
+// Only the provided columns are returned.
+func returningClauses(c database.Connection, statement string, columns iop.Columns) (output, returning string, err error) {
+	fields := lo.Map(columns, func(col iop.Column, i int) string {
+		return c.Quote(col.Name, false)
+	})
+	if len(fields) == 0 {
+		return "", "", g.Error("no readable columns to return")
+	}
+
+	switch c.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbSQLite, dbio.TypeDbDuckDb, dbio.TypeDbMotherDuck:
+		return "", "returning " + strings.Join(fields, ", "), nil
+	case dbio.TypeDbMariaDB:
+		if statement == "insert" {
+			return "", "returning " + strings.Join(fields, ", "), nil
+		}
+	case dbio.TypeDbSQLServer, dbio.TypeDbAzure:
+		fields = lo.Map(fields, func(field string, i int) string { return "inserted." + field })
+		return "output " + strings.Join(fields, ", "), "", nil
+	}
+	return "", "", g.Error("returning the %sed rows is not supported for %s", strings.TrimSuffix(statement, "e"), c.GetType())
+}
+
+// insertReturning inserts the stream rows in batches of multi-row inserts,
+// collecting the rows returned by the database (with generated values)
+func insertReturning(c database.Connection, req *Request, fr *FilterRenderer, ds *iop.Datastream, returnColumns iop.Columns) (data iop.Dataset, err error) {
+	output, returning, err := returningClauses(c, "insert", returnColumns)
+	if err != nil {
+		return data, err
+	}
+
+	fields := []string{}
+	for _, dsCol := range ds.Columns {
+		col, err := fr.Column(dsCol.Name)
+		if err != nil {
+			return data, g.Error(err, "invalid input column")
+		}
+		fields = append(fields, c.Quote(col.Name, false))
+	}
+	batchSize := lo.Max([]int{1, maxBindValues / lo.Max([]int{1, len(fields)})})
+
+	ctx := req.echoCtx.Request().Context()
+	err = c.BeginContext(ctx)
+	if err != nil {
+		err = g.Error(err, "could not begin transaction")
+		return
+	}
+
+	insertBatch := func(rows [][]any) (err error) {
+		fr.Args = []any{}
+		valueRows := lo.Map(rows, func(row []any, i int) string {
+			values := lo.Map(row, func(val any, j int) string { return fr.Arg(val) })
+			return "(" + strings.Join(values, ", ") + ")"
+		})
+
+		sql := g.R(
+			"insert into {table} ({fields}){output} values {values}{returning}",
+			"table", req.dbTable.FullName(),
+			"fields", strings.Join(fields, ", "),
+			"output", lo.Ternary(output != "", " "+output, ""),
+			"values", strings.Join(valueRows, ", "),
+			"returning", lo.Ternary(returning != "", " "+returning, ""),
+		)
+
+		batchData, err := queryRows(c, req, sql, fr.Args, returnColumns)
+		if err != nil {
+			return err
+		}
+
+		if len(data.Columns) == 0 {
+			data = iop.NewDataset(batchData.Columns)
+		}
+		data.Rows = append(data.Rows, batchData.Rows...)
+		return nil
+	}
+
+	batch := [][]any{}
+	for row := range ds.Rows() {
+		batch = append(batch, row)
+		if len(batch) >= batchSize {
+			if err = insertBatch(batch); err != nil {
+				break
+			}
+			batch = [][]any{}
+		}
+	}
+
+	if err == nil && len(batch) > 0 {
+		err = insertBatch(batch)
+	}
+
+	if err == nil {
+		err = ds.Err()
+	}
+
+	if err != nil {
+		c.Rollback()
+		err = g.Error(err, "could not insert into table")
+		return
+	}
+
+	err = c.Commit()
+	if err != nil {
+		err = g.Error(err, "could not commit transaction")
+		return
+	}
+
+	return
+}
+
+// queryRows executes the statement in the current transaction
+// and collects the returned rows. Column types are taken from
+// the table columns when matching
+func queryRows(c database.Connection, req *Request, sql string, args []any, tableColumns iop.Columns) (data iop.Dataset, err error) {
+	ctx := req.echoCtx.Request().Context()
+	result, err := c.Tx().QueryContext(ctx, sql, args...)
+	if err != nil {
+		return data, g.Error(err, "could not execute statement")
+	}
+	defer result.Close()
+
+	fields, err := result.Columns()
+	if err != nil {
+		return data, g.Error(err, "could not get result columns")
+	}
+
+	data = iop.NewDataset(resultColumns(fields, tableColumns))
+	for result.Next() {
+		row, err := result.SliceScan()
+		if err != nil {
+			return data, g.Error(err, "could not scan row")
+		}
+		data.Append(row)
+	}
+
+	return data, result.Err()
+}
+
+// resultColumns returns the columns of the result fields. Column types
+// are taken from the table columns when matching, else are strings
+func resultColumns(fields []string, tableColumns iop.Columns) (columns iop.Columns) {
+	columns = iop.Columns{}
+	for i, field := range fields {
+		col := iop.Column{Name: field, Position: i + 1, Type: iop.StringType}
+		if known := tableColumns.GetColumn(field); known != nil {
+			col.Type = known.Type
+			col.DbType = known.DbType
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
@@ -18,12 +18,36 @@ var StandardRoutes = []echo.Route{
 		Path:    "/.status",
 		Handler: getStatus,
 	},
+	{
+		Name:    "getMetrics",
+		Method:  "GET",
+		Path:    "/.metrics",
+		Handler: getMetrics,
+	},
+	{
+		Name:    "getOpenAPI",
+		Method:  "GET",
+		Path:    "/.openapi.json",
+		Handler: getOpenAPI,
+	},
+	{
+		Name:    "getDocs",
+		Method:  "GET",
+		Path:    "/.docs",
+		Handler: getDocs,
+	},
 	{
 		Name:    "getConnections",
 		Method:  "GET",
 		Path:    "/.connections",
 		Handler: getConnections,
 	},
+	{
+		Name:    "getAllActiveQueries",
+		Method:  "GET",
+		Path:    "/.queries/active",
+		Handler: getAllActiveQueries,
+	},
 	{
 		Name:    "closeConnection",
 		Method:  "POST",
@@ -72,6 +96,66 @@ var StandardRoutes = []echo.Route{
 		Path:    "/:connection/.cancel/:id",
 		Handler: postConnectionCancel,
 	},
+	{
+		Name:    "submitJob",
+		Method:  "POST",
+		Path:    "/:connection/.jobs",
+		Handler: postJob,
+	},
+	{
+		Name:    "getJob",
+		Method:  "GET",
+		Path:    "/:connection/.jobs/:id",
+		Handler: getJob,
+	},
+	{
+		Name:    "getJobResult",
+		Method:  "GET",
+		Path:    "/:connection/.jobs/:id/result",
+		Handler: getJobResult,
+	},
+	{
+		Name:    "deleteJob",
+		Method:  "DELETE",
+		Path:    "/:connection/.jobs/:id",
+		Handler: deleteJob,
+	},
+	{
+		Name:    "getActiveQueries",
+		Method:  "GET",
+		Path:    "/:connection/.queries/active",
+		Handler: getActiveQueries,
+	},
+	{
+		Name:    "getQueryHistory",
+		Method:  "GET",
+		Path:    "/:connection/.queries/history",
+		Handler: getQueryHistory,
+	},
+	{
+		Name:    "getSavedQuery",
+		Method:  "GET",
+		Path:    "/:connection/.queries/:name",
+		Handler: getSavedQuery,
+	},
+	{
+		Name:    "postSavedQuery",
+		Method:  "POST",
+		Path:    "/:connection/.queries/:name",
+		Handler: postSavedQuery,
+	},
+	{
+		Name:    "getGraphQL",
+		Method:  "GET",
+		Path:    "/:connection/.graphql",
+		Handler: getConnectionGraphQL,
+	},
+	{
+		Name:    "postGraphQL",
+		Method:  "POST",
+		Path:    "/:connection/.graphql",
+		Handler: postConnectionGraphQL,
+	},
 	{
 		Name:    "getSchemaTables",
 		Method:  "GET",
@@ -84,6 +168,12 @@ var StandardRoutes = []echo.Route{
 		Path:    "/:connection/:schema/.columns",
 		Handler: getSchemaColumns,
 	},
+	{
+		Name:    "callRoutine",
+		Method:  "POST",
+		Path:    "/:connection/:schema/.rpc/:procedure",
+		Handler: postRoutineCall,
+	},
 	{
 		Name:    "getTableColumns",
 		Method:  "GET",
@@ -126,6 +216,12 @@ var StandardRoutes = []echo.Route{
 		Path:    "/:connection/:schema/:table",
 		Handler: getTableSelect,
 	},
+	{
+		Name:    "tableDelete",
+		Method:  "DELETE",
+		Path:    "/:connection/:schema/:table",
+		Handler: deleteTableRows,
+	},
 }
 
 func getStatus(c echo.Context) (err error) {
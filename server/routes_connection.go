@@ -58,7 +58,7 @@ func closeConnection(c echo.Context) (err error) {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		err = c.Close()
 		return
 	}
@@ -82,7 +82,7 @@ func getConnectionDatabases(c echo.Context) (err error) {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		return c.GetDatabases()
 	}
 
@@ -107,12 +107,12 @@ func getConnectionSchemas(c echo.Context) (err error) {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		data, err = c.GetSchemas()
 		data.Rows = lo.Filter(data.Rows, func(row []any, i int) bool {
 			schema := strings.ToLower(cast.ToString(row[0]))
 			ts := req.Project.SchemaAll(req.Connection, schema)
-			return req.CanRead(ts) || req.CanWrite(ts)
+			return req.Permissions.InSchema(ts)
 		})
 
 		return
@@ -191,10 +191,10 @@ func getSchemaColumns(c echo.Context) (err error) {
 	return resp.Make()
 }
 
-func getSchemataTables(req Request) (resp Response, err error) {
+func getSchemataTables(req *Request) (resp Response, err error) {
 	resp = NewResponse(req)
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		schemata, err := c.GetSchemata(database.SchemataLevelTable, req.Schema)
 		if err != nil {
 			err = g.Error(err, "could not get tables")
@@ -209,7 +209,7 @@ func getSchemataTables(req Request) (resp Response, err error) {
 		}
 		data = iop.NewDataset(columns)
 		for _, table := range schemata.Tables() {
-			if !(req.CanRead(table) || req.CanWrite(table)) {
+			if !req.CanAccess(table) {
 				continue
 			}
 			row := []any{
@@ -238,11 +238,11 @@ func getSchemataTables(req Request) (resp Response, err error) {
 	return resp, nil
 }
 
-func getSchemataColumns(req Request) (resp Response, err error) {
+func getSchemataColumns(req *Request) (resp Response, err error) {
 
 	resp = NewResponse(req)
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		schemata, err := c.GetSchemata(database.SchemataLevelColumn, req.Schema, req.Table)
 		if err != nil {
 			err = g.Error(err, "could not get columns")
@@ -260,11 +260,11 @@ func getSchemataColumns(req Request) (resp Response, err error) {
 		}
 		data = iop.NewDataset(columns)
 		for _, table := range schemata.Tables() {
-			if !(req.CanRead(table) || req.CanWrite(table)) {
+			if !req.CanAccess(table) {
 				continue
 			}
 
-			for _, column := range table.Columns {
+			for _, column := range req.VisibleColumns(table, table.Columns) {
 				row := []any{
 					table.Database,
 					table.Schema,
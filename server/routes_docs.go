@@ -0,0 +1,17 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+)
+
+// docsPage is the API explorer, driven by /.openapi.json
+//
+//go:embed docs/index.html
+var docsPage string
+
+func getDocs(c echo.Context) (err error) {
+	return c.HTML(http.StatusOK, docsPage)
+}
@@ -0,0 +1,1085 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+const (
+	// gqlDefaultLimit is the number of rows of a root field without `limit`
+	gqlDefaultLimit = 100
+	// gqlMaxLimit is the maximum `limit` of a field
+	gqlMaxLimit = 1000
+	// gqlMaxDepth is the maximum nesting of relation fields
+	gqlMaxDepth = 5
+	// gqlMaxRows is the maximum number of rows fetched for a request
+	gqlMaxRows = 10000
+)
+
+// gqlRequest is the body of a GraphQL request
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// gqlError is an error of the GraphQL response
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// gqlResponse is the GraphQL response
+type gqlResponse struct {
+	Data   any        `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+// getConnectionGraphQL returns the schema (SDL) of the tables readable by
+// the token, or executes the query of the `query` param
+func getConnectionGraphQL(c echo.Context) (err error) {
+	if c.QueryParam("query") == "" {
+		req := NewRequest(c)
+		if err = req.Validate(reqCheckConnection); err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "invalid request")
+		}
+
+		schema, err := getGraphQLSchema(req)
+		if err != nil {
+			return ErrJSON(http.StatusInternalServerError, err, "could not build GraphQL schema")
+		}
+		return c.String(http.StatusOK, schema.SDL())
+	}
+
+	gr := gqlRequest{
+		Query:         c.QueryParam("query"),
+		OperationName: c.QueryParam("operationName"),
+	}
+	if variables := c.QueryParam("variables"); variables != "" {
+		if err = json.Unmarshal([]byte(variables), &gr.Variables); err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "invalid variables")
+		}
+	}
+
+	return processGraphQLRequest(c, gr)
+}
+
+// postConnectionGraphQL executes the GraphQL query of the body
+func postConnectionGraphQL(c echo.Context) (err error) {
+	gr := gqlRequest{}
+	body, _ := io.ReadAll(c.Request().Body)
+	if err = json.Unmarshal(body, &gr); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid GraphQL request")
+	}
+
+	return processGraphQLRequest(c, gr)
+}
+
+func processGraphQLRequest(c echo.Context, gr gqlRequest) (err error) {
+	req := NewRequest(c)
+	req.Query = gr.Query
+
+	if err = req.Validate(reqCheckConnection, reqCheckQuery); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	}
+
+	badRequest := func(err error) error {
+		return c.JSON(http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: g.ErrMsgSimple(err)}}})
+	}
+
+	doc, err := parseGraphQL(gr.Query)
+	if err != nil {
+		return badRequest(g.Error(err, "could not parse query"))
+	}
+
+	operation, err := doc.Operation(gr.OperationName)
+	if err != nil {
+		return badRequest(err)
+	} else if operation.Type != "query" {
+		return badRequest(g.Error("only queries are supported, not %s", operation.Type))
+	}
+
+	variables, err := operation.VariableValues(gr.Variables)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	schema, err := getGraphQLSchema(req)
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not build GraphQL schema")
+	}
+
+	ex := &gqlExecutor{req: req, schema: schema, doc: doc, variables: variables}
+	ex.conn, err = req.Project.GetConnInstance(req.Connection, req.Database)
+	if err != nil {
+		return ErrJSON(http.StatusNotFound, err, "could not find connection: %s", req.Connection)
+	}
+
+	data := ex.Execute(operation)
+
+	// for middleware
+	req.echoCtx.Set("sql", strings.Join(ex.sqls, ";\n"))
+	req.echoCtx.Set("rows", ex.rows)
+
+	return c.JSON(http.StatusOK, gqlResponse{Data: data, Errors: ex.errors})
+}
+
+// gqlTable is a table exposed as a GraphQL object type
+type gqlTable struct {
+	Name      string // type and root field name
+	Table     database.Table
+	Columns   iop.Columns            // readable columns
+	Fields    map[string]iop.Column  // by field name
+	Relations map[string]gqlRelation // by field name
+	order     []string               // field names in order
+}
+
+// gqlRelation is a nested field following a foreign key. Forward
+// relations return the referenced row, reverse relations return the
+// list of referencing rows.
+type gqlRelation struct {
+	Target       *gqlTable
+	List         bool
+	Column       string // column of the parent row
+	TargetColumn string // column of the target rows
+}
+
+// gqlSchema is the GraphQL schema of the tables readable by a token
+type gqlSchema struct {
+	Tables map[string]*gqlTable // by type name
+	names  []string
+}
+
+// foreignKey is a single-column foreign key
+type foreignKey struct {
+	Table     database.Table
+	Column    string
+	RefTable  database.Table
+	RefColumn string
+}
+
+// gqlMetadata is the cached tables and foreign keys of a connection
+type gqlMetadata struct {
+	Tables      []database.Table
+	ForeignKeys []foreignKey
+	Time        time.Time
+}
+
+var (
+	gqlMetadataCache = map[string]gqlMetadata{}
+	gqlMetadataMux   sync.Mutex
+	gqlMetadataTTL   = time.Minute
+)
+
+// getGraphQLMetadata returns the tables and foreign keys of the
+// connection, cached for a minute
+func getGraphQLMetadata(req *Request) (metadata gqlMetadata, err error) {
+	key := strings.Join([]string{req.Project.ID, req.Connection, req.Database}, "|")
+
+	gqlMetadataMux.Lock()
+	metadata, ok := gqlMetadataCache[key]
+	gqlMetadataMux.Unlock()
+	if ok && time.Since(metadata.Time) < gqlMetadataTTL {
+		return metadata, nil
+	}
+
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		schemata, err := c.GetSchemata(database.SchemataLevelColumn, "")
+		if err != nil {
+			err = g.Error(err, "could not get columns")
+			return
+		}
+
+		metadata = gqlMetadata{Tables: lo.Values(schemata.Tables()), Time: time.Now()}
+		metadata.ForeignKeys, err = getForeignKeys(c)
+		if err != nil {
+			// relations are optional
+			g.Warn("could not get foreign keys of %s: %s", req.Connection, g.ErrMsgSimple(err))
+			err = nil
+		}
+		return
+	}
+
+	if _, err = ProcessRequest(req, rf); err != nil {
+		return metadata, err
+	}
+
+	gqlMetadataMux.Lock()
+	gqlMetadataCache[key] = metadata
+	gqlMetadataMux.Unlock()
+
+	return metadata, nil
+}
+
+// foreignKeySQL are the queries listing the foreign key columns, by
+// connection type, returning schema_name, table_name, column_name,
+// ref_schema_name, ref_table_name, ref_column_name and constraint_name
+var foreignKeySQL = map[dbio.Type]string{
+	dbio.TypeDbSQLite: `select 'main' as schema_name, m.name as table_name, p."from" as column_name,
+		'main' as ref_schema_name, p."table" as ref_table_name, p."to" as ref_column_name,
+		m.name || '.' || p.id as constraint_name
+		from sqlite_master m join pragma_foreign_key_list(m.name) p
+		where m.type = 'table'`,
+	dbio.TypeDbPostgres: `select kcu.table_schema as schema_name, kcu.table_name, kcu.column_name,
+		ccu.table_schema as ref_schema_name, ccu.table_name as ref_table_name, ccu.column_name as ref_column_name,
+		tc.constraint_name
+		from information_schema.table_constraints tc
+		join information_schema.key_column_usage kcu
+			on kcu.constraint_name = tc.constraint_name and kcu.table_schema = tc.table_schema
+		join information_schema.constraint_column_usage ccu
+			on ccu.constraint_name = tc.constraint_name and ccu.table_schema = tc.table_schema
+		where tc.constraint_type = 'FOREIGN KEY'`,
+	dbio.TypeDbMySQL: `select table_schema as schema_name, table_name, column_name,
+		referenced_table_schema as ref_schema_name, referenced_table_name as ref_table_name,
+		referenced_column_name as ref_column_name, constraint_name
+		from information_schema.key_column_usage
+		where referenced_table_name is not null`,
+	dbio.TypeDbOracle: `select a.owner as schema_name, a.table_name, a.column_name,
+		b.owner as ref_schema_name, b.table_name as ref_table_name, b.column_name as ref_column_name,
+		a.constraint_name
+		from all_cons_columns a
+		join all_constraints c on c.owner = a.owner and c.constraint_name = a.constraint_name
+		join all_cons_columns b on b.owner = c.r_owner and b.constraint_name = c.r_constraint_name and b.position = a.position
+		where c.constraint_type = 'R'`,
+}
+
+func init() {
+	foreignKeySQL[dbio.TypeDbRedshift] = foreignKeySQL[dbio.TypeDbPostgres]
+	foreignKeySQL[dbio.TypeDbSQLServer] = foreignKeySQL[dbio.TypeDbPostgres]
+	foreignKeySQL[dbio.TypeDbAzure] = foreignKeySQL[dbio.TypeDbPostgres]
+	foreignKeySQL[dbio.TypeDbMariaDB] = foreignKeySQL[dbio.TypeDbMySQL]
+}
+
+// getForeignKeys returns the single-column foreign keys of the connection.
+// Composite foreign keys are skipped.
+func getForeignKeys(conn database.Connection) (keys []foreignKey, err error) {
+	sql, ok := foreignKeySQL[conn.GetType()]
+	if !ok {
+		return nil, nil // foreign keys are not supported
+	}
+
+	data, err := conn.Query(sql)
+	if err != nil {
+		return nil, g.Error(err, "could not query foreign keys")
+	}
+
+	records := data.Records(true)
+	counts := map[string]int{}
+	constraintKey := func(rec map[string]any) string {
+		return strings.Join([]string{cast.ToString(rec["schema_name"]), cast.ToString(rec["table_name"]), cast.ToString(rec["constraint_name"])}, ".")
+	}
+	for _, rec := range records {
+		counts[constraintKey(rec)]++
+	}
+
+	for _, rec := range records {
+		if counts[constraintKey(rec)] > 1 {
+			continue
+		}
+
+		key := foreignKey{
+			Table:     database.Table{Schema: cast.ToString(rec["schema_name"]), Name: cast.ToString(rec["table_name"]), Dialect: conn.GetType()},
+			Column:    cast.ToString(rec["column_name"]),
+			RefTable:  database.Table{Schema: cast.ToString(rec["ref_schema_name"]), Name: cast.ToString(rec["ref_table_name"]), Dialect: conn.GetType()},
+			RefColumn: cast.ToString(rec["ref_column_name"]),
+		}
+
+		if key.RefColumn == "" {
+			// sqlite: the referenced column defaults to the primary key
+			pkColumns, err := getPrimaryKeys(conn, key.RefTable)
+			if err != nil || len(pkColumns) != 1 {
+				continue
+			}
+			key.RefColumn = pkColumns[0]
+		}
+
+		keys = append(keys, key)
+	}
+
+	return
+}
+
+// getGraphQLSchema builds the schema of the tables and columns readable
+// by the request, with the relations of the foreign keys between them
+func getGraphQLSchema(req *Request) (schema *gqlSchema, err error) {
+	metadata, err := getGraphQLMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := lo.Filter(metadata.Tables, func(table database.Table, i int) bool {
+		return req.CanRead(table)
+	})
+	sort.Slice(tables, func(i, j int) bool { return tables[i].FullName() < tables[j].FullName() })
+
+	schema = &gqlSchema{Tables: map[string]*gqlTable{}}
+	byTable := map[string]*gqlTable{}
+	tableKey := func(table database.Table) string {
+		return strings.ToLower(table.Schema + "." + table.Name)
+	}
+
+	for _, table := range tables {
+		columns := req.ReadableColumns(table, table.Columns)
+		if len(columns) == 0 {
+			continue
+		}
+
+		name := gqlName(table.Schema + "_" + table.Name)
+		for i := 2; schema.Tables[name] != nil; i++ {
+			name = gqlName(g.F("%s_%s_%d", table.Schema, table.Name, i))
+		}
+
+		t := &gqlTable{
+			Name:      name,
+			Table:     table,
+			Columns:   columns,
+			Fields:    map[string]iop.Column{},
+			Relations: map[string]gqlRelation{},
+		}
+		for _, col := range columns {
+			if fieldName := gqlName(col.Name); t.Fields[fieldName].Name == "" {
+				t.Fields[fieldName] = col
+				t.order = append(t.order, fieldName)
+			}
+		}
+
+		schema.Tables[name] = t
+		schema.names = append(schema.names, name)
+		byTable[tableKey(table)] = t
+	}
+
+	// relations, when both tables and join columns are readable
+	keys := metadata.ForeignKeys
+	sort.Slice(keys, func(i, j int) bool {
+		return tableKey(keys[i].Table)+"."+keys[i].Column < tableKey(keys[j].Table)+"."+keys[j].Column
+	})
+	keyCounts := map[string]int{} // keys between the same tables
+	for _, key := range keys {
+		keyCounts[tableKey(key.Table)+">"+tableKey(key.RefTable)]++
+	}
+
+	for _, key := range keys {
+		source, target := byTable[tableKey(key.Table)], byTable[tableKey(key.RefTable)]
+		if source == nil || target == nil {
+			continue
+		}
+
+		column := source.Columns.GetColumn(key.Column)
+		refColumn := target.Columns.GetColumn(key.RefColumn)
+		if column == nil || refColumn == nil {
+			continue
+		}
+
+		// forward: the referenced row, named after the column
+		name := gqlName(strings.TrimSuffix(strings.ToLower(column.Name), "_id"))
+		if name == gqlName(strings.ToLower(column.Name)) {
+			name = gqlName(target.Table.Name)
+		}
+		if source.hasField(name) {
+			name = gqlName(target.Table.Name + "_by_" + column.Name)
+		}
+		if !source.hasField(name) {
+			source.Relations[name] = gqlRelation{Target: target, Column: column.Name, TargetColumn: refColumn.Name}
+			source.order = append(source.order, name)
+		}
+
+		// reverse: the referencing rows, named after the table
+		name = gqlName(source.Table.Name)
+		if keyCounts[tableKey(key.Table)+">"+tableKey(key.RefTable)] > 1 || target.hasField(name) {
+			name = gqlName(source.Table.Name + "_by_" + column.Name)
+		}
+		if !target.hasField(name) {
+			target.Relations[name] = gqlRelation{Target: source, List: true, Column: refColumn.Name, TargetColumn: column.Name}
+			target.order = append(target.order, name)
+		}
+	}
+
+	return schema, nil
+}
+
+func (t *gqlTable) hasField(name string) bool {
+	_, isRelation := t.Relations[name]
+	return t.Fields[name].Name != "" || isRelation
+}
+
+// gqlName returns a valid GraphQL name for the identifier
+func gqlName(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// gqlScalar returns the GraphQL scalar type of the column
+func gqlScalar(col iop.Column) string {
+	switch {
+	case col.Type.IsInteger():
+		return "Int"
+	case col.Type.IsDecimal():
+		return "Float"
+	case col.Type.IsBool():
+		return "Boolean"
+	case col.Type == iop.JsonType:
+		return "JSON"
+	}
+	return "String"
+}
+
+// gqlExecutor executes a query operation on the schema
+type gqlExecutor struct {
+	req       *Request
+	conn      database.Connection
+	schema    *gqlSchema
+	doc       gqlDocument
+	variables map[string]any
+	errors    []gqlError
+	sqls      []string // executed statements, for the audit log
+	rows      int
+}
+
+// Execute executes the root fields of the operation. A failing field is
+// null, with its error in the response errors.
+func (ex *gqlExecutor) Execute(op gqlOperation) (data gqlObject) {
+	data = gqlObject{}
+
+	fields, err := ex.collectFields(op.Selections, "Query")
+	if err != nil {
+		ex.addError(err, nil)
+		return nil
+	}
+
+	for _, field := range fields {
+		path := []any{field.Key()}
+		switch field.Name {
+		case "__typename":
+			data.Set(field.Key(), "Query")
+		case "__schema":
+			data.Set(field.Key(), ex.project(ex.schema.Introspection(), field.Selections, path))
+		case "__type":
+			name := cast.ToString(ex.args(field)["name"])
+			if typ, ok := ex.schema.introspectionTypes()[name]; ok {
+				data.Set(field.Key(), ex.project(typ, field.Selections, path))
+			} else {
+				data.Set(field.Key(), nil)
+			}
+		default:
+			table := ex.schema.Tables[field.Name]
+			if table == nil {
+				ex.addError(g.Error("field %s not found on type Query", field.Name), path)
+				data.Set(field.Key(), nil)
+				continue
+			}
+
+			rows, err := ex.fetchRows(table, field, nil, true)
+			if err == nil {
+				var objects []gqlObject
+				objects, err = ex.resolveObjects(table, field.Selections, rows, path)
+				data.Set(field.Key(), objects)
+			}
+			if err != nil {
+				ex.addError(err, path)
+				data.Set(field.Key(), nil)
+			}
+		}
+	}
+
+	return data
+}
+
+func (ex *gqlExecutor) addError(err error, path []any) {
+	ex.errors = append(ex.errors, gqlError{Message: g.ErrMsgSimple(err), Path: path})
+}
+
+// args returns the field arguments, with the variables replaced
+func (ex *gqlExecutor) args(field gqlSelection) map[string]any {
+	return gqlResolveValue(field.Args, ex.variables).(map[string]any)
+}
+
+// collectFields expands the fragments of the selection set for the type,
+// skips the fields excluded by @skip / @include, and merges the fields
+// with the same response key
+func (ex *gqlExecutor) collectFields(selections []gqlSelection, typeName string) (fields []gqlSelection, err error) {
+	index := map[string]int{}
+
+	var collect func(selections []gqlSelection, visited []string) error
+	collect = func(selections []gqlSelection, visited []string) error {
+		for _, s := range selections {
+			if !ex.included(s.Directives) {
+				continue
+			}
+
+			switch {
+			case s.Spread != "":
+				fragment, ok := ex.doc.Fragments[s.Spread]
+				if !ok {
+					return g.Error("fragment %s not found", s.Spread)
+				} else if lo.Contains(visited, s.Spread) {
+					return g.Error("fragment %s spreads itself", s.Spread)
+				} else if fragment.On != typeName {
+					continue
+				}
+				if err := collect(fragment.Selections, append(visited, s.Spread)); err != nil {
+					return err
+				}
+			case s.Inline:
+				if s.On != "" && s.On != typeName {
+					continue
+				}
+				if err := collect(s.Selections, visited); err != nil {
+					return err
+				}
+			default:
+				if i, ok := index[s.Key()]; ok {
+					if fields[i].Name != s.Name {
+						return g.Error("fields %s and %s conflict on key %s", fields[i].Name, s.Name, s.Key())
+					}
+					fields[i].Selections = append(fields[i].Selections, s.Selections...)
+					continue
+				}
+				index[s.Key()] = len(fields)
+				fields = append(fields, s)
+			}
+		}
+		return nil
+	}
+
+	err = collect(selections, nil)
+	return
+}
+
+// included evaluates the @skip and @include directives
+func (ex *gqlExecutor) included(directives []gqlDirective) bool {
+	for _, directive := range directives {
+		value := cast.ToBool(gqlResolveValue(directive.Args["if"], ex.variables))
+		if (directive.Name == "skip" && value) || (directive.Name == "include" && !value) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchRows selects the rows of the table with the columns needed by the
+// selection set. The key filter restricts the rows to the parent keys of
+// a relation. Returns the rows as column values.
+func (ex *gqlExecutor) fetchRows(t *gqlTable, field gqlSelection, keyFilter *Filter, paginate bool) (rows []map[string]any, err error) {
+	fields, err := ex.collectFields(field.Selections, t.Name)
+	if err != nil {
+		return nil, err
+	} else if len(field.Selections) == 0 {
+		return nil, g.Error("field %s of type [%s] must have a selection of subfields", field.Name, t.Name)
+	}
+
+	ts := &TableSelect{Table: t.Table}
+	addColumn := func(col iop.Column) {
+		if ts.Fields.GetColumn(col.Name) == nil {
+			ts.Fields = append(ts.Fields, col)
+		}
+	}
+
+	for _, f := range fields {
+		if col, ok := t.Fields[f.Name]; ok {
+			addColumn(col)
+		} else if relation, ok := t.Relations[f.Name]; ok {
+			addColumn(*t.Columns.GetColumn(relation.Column))
+		} else if f.Name != "__typename" {
+			return nil, g.Error("field %s not found on type %s", f.Name, t.Name)
+		}
+	}
+	if keyFilter != nil {
+		addColumn(*t.Columns.GetColumn(keyFilter.Column)) // to group by parent
+	} else if len(ts.Fields) == 0 {
+		addColumn(t.Columns[0]) // only __typename
+	}
+
+	args := ex.args(field)
+	if !paginate && len(args) > 0 {
+		return nil, g.Error("field %s does not accept arguments", field.Name)
+	}
+
+	for name, value := range args {
+		switch name {
+		case "where":
+			filter, err := ex.where(t, value)
+			if err != nil {
+				return nil, g.Error(err, "invalid where")
+			} else if filter != nil {
+				ts.Filters = append(ts.Filters, *filter)
+			}
+		case "order_by":
+			if ts.Order, err = ex.orderBy(t, value); err != nil {
+				return nil, g.Error(err, "invalid order_by")
+			}
+		case "limit", "offset":
+			number, err := cast.ToIntE(value)
+			if err != nil || number < 0 {
+				return nil, g.Error("invalid %s: %v", name, value)
+			} else if name == "limit" && number > gqlMaxLimit {
+				return nil, g.Error("invalid limit: %d, the maximum is %d", number, gqlMaxLimit)
+			} else if keyFilter != nil {
+				continue // applied per parent
+			}
+			if name == "limit" {
+				ts.Limit = number
+			} else {
+				ts.Offset = number
+			}
+		default:
+			return nil, g.Error("unknown argument %s on field %s", name, field.Name)
+		}
+	}
+
+	if keyFilter != nil {
+		ts.Filters = append(ts.Filters, *keyFilter)
+	} else if args["limit"] == nil {
+		ts.Limit = gqlDefaultLimit
+	}
+
+	// the rows of the request are bounded, one more row is
+	// fetched to detect that the budget is exceeded
+	remaining := gqlMaxRows - ex.rows
+	if ts.Limit <= 0 || ts.Limit > remaining {
+		ts.Limit = remaining + 1
+	}
+
+	// rows are restricted by the row filters of the roles
+	fr := NewFilterRenderer(ex.conn, t.Columns)
+	fr.RowFilters, err = ex.req.RowFilters(state.OperationSelect, t.Table)
+	if err != nil {
+		return nil, err
+	}
+	fr.Attributes = ex.req.Attributes
+
+	sql, err := ts.SQL(fr)
+	if err != nil {
+		return nil, g.Error(err, "could not construct query")
+	}
+
+	data, err := ex.query(sql, fr.Args, ts.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range data.Rows {
+		rec := map[string]any{}
+		for i, col := range ts.Fields {
+			if i < len(row) {
+				rec[col.Name] = gqlValue(col, row[i])
+			}
+		}
+		rows = append(rows, rec)
+	}
+	ex.rows += len(rows)
+
+	if ex.rows > gqlMaxRows {
+		return nil, g.Error("too many rows, the maximum is %d per request", gqlMaxRows)
+	}
+
+	return rows, nil
+}
+
+// query executes the select query and collects the rows
+func (ex *gqlExecutor) query(sql string, args []any, columns iop.Columns) (data iop.Dataset, err error) {
+	query := ex.req.Project.NewQuery(context.Background())
+	query.Conn = ex.req.Connection
+	query.Database = ex.req.Database
+	query.Text = sql
+	query.Args = args
+	query.Columns = columns
+	query.ID = g.NewTsID("gql")
+	query.Token = ex.req.Token
+	query.Limit = -1
+
+	ex.sqls = append(ex.sqls, sql)
+
+	query, err = state.SubmitOrGetQuery(query, false)
+	if err != nil {
+		return data, g.Error(err, "could not submit query")
+	}
+	<-query.Done
+
+	if query.Error == nil && query.Stream != nil {
+		data, err = query.Stream.Collect(0)
+	}
+	if perr := query.ProcessResult(); perr != nil {
+		return data, g.Error(perr, "could not execute query")
+	} else if err != nil {
+		return data, g.Error(err, "could not collect rows")
+	}
+
+	return data, nil
+}
+
+// resolveObjects builds the response objects of the rows, resolving
+// the relations with one query per relation field
+func (ex *gqlExecutor) resolveObjects(t *gqlTable, selections []gqlSelection, rows []map[string]any, path []any) (objects []gqlObject, err error) {
+	fields, err := ex.collectFields(selections, t.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	related := map[string][]any{} // relation values by response key, per row
+	for _, field := range fields {
+		relation, ok := t.Relations[field.Name]
+		if !ok {
+			continue
+		} else if len(path) > gqlMaxDepth {
+			return nil, g.Error("field %s exceeds the maximum depth of %d nested fields", field.Name, gqlMaxDepth)
+		}
+
+		values, err := ex.resolveRelation(relation, field, rows, append(path[:len(path):len(path)], field.Key()))
+		if err != nil {
+			return nil, err
+		}
+		related[field.Key()] = values
+	}
+
+	objects = make([]gqlObject, len(rows))
+	for i, row := range rows {
+		object := gqlObject{}
+		for _, field := range fields {
+			switch {
+			case field.Name == "__typename":
+				object.Set(field.Key(), t.Name)
+			case related[field.Key()] != nil:
+				object.Set(field.Key(), related[field.Key()][i])
+			default:
+				object.Set(field.Key(), row[t.Fields[field.Name].Name])
+			}
+		}
+		objects[i] = object
+	}
+
+	return objects, nil
+}
+
+// resolveRelation fetches the related rows of all the parent rows, and
+// returns the value of the relation field of each parent row
+func (ex *gqlExecutor) resolveRelation(relation gqlRelation, field gqlSelection, rows []map[string]any, path []any) (values []any, err error) {
+	// limit and offset are applied per parent row
+	args := ex.args(field)
+	limit, err := cast.ToIntE(args["limit"])
+	if err != nil || limit < 0 {
+		return nil, g.Error("invalid limit: %v", args["limit"])
+	}
+	offset, err := cast.ToIntE(args["offset"])
+	if err != nil || offset < 0 {
+		return nil, g.Error("invalid offset: %v", args["offset"])
+	}
+
+	keys := []string{}
+	for _, row := range rows {
+		if value := row[relation.Column]; value != nil {
+			keys = append(keys, cast.ToString(value))
+		}
+	}
+	keys = lo.Uniq(keys)
+
+	// fetch the related rows in batches of keys
+	var targetRows []map[string]any
+	for _, batch := range lo.Chunk(keys, 500) {
+		filter := &Filter{Column: relation.TargetColumn, Operator: FilterOpIn, Values: batch}
+		batchRows, err := ex.fetchRows(relation.Target, field, filter, relation.List)
+		if err != nil {
+			return nil, err
+		}
+		targetRows = append(targetRows, batchRows...)
+	}
+
+	objects, err := ex.resolveObjects(relation.Target, field.Selections, targetRows, path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string][]gqlObject{}
+	for i, row := range targetRows {
+		key := cast.ToString(row[relation.TargetColumn])
+		groups[key] = append(groups[key], objects[i])
+	}
+
+	values = make([]any, len(rows))
+	for i, row := range rows {
+		var group []gqlObject
+		if value := row[relation.Column]; value != nil {
+			group = groups[cast.ToString(value)]
+		}
+
+		if !relation.List {
+			if len(group) > 0 {
+				values[i] = group[0]
+			}
+			continue
+		}
+
+		group = group[min(offset, len(group)):]
+		if limit > 0 && len(group) > limit {
+			group = group[:limit]
+		}
+		values[i] = lo.Ternary(group == nil, []gqlObject{}, group)
+	}
+
+	return values, nil
+}
+
+// gqlValue converts the column value for the response
+func gqlValue(col iop.Column, value any) any {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if value == nil {
+		return nil
+	}
+
+	switch {
+	case col.Type.IsInteger():
+		if number, err := cast.ToInt64E(value); err == nil {
+			return number
+		}
+	case col.Type.IsDecimal():
+		if number, err := cast.ToFloat64E(value); err == nil {
+			return number
+		}
+	case col.Type.IsBool():
+		if b, err := cast.ToBoolE(value); err == nil {
+			return b
+		}
+	case col.Type == iop.JsonType:
+		if s, ok := value.(string); ok {
+			var v any
+			if err := json.Unmarshal([]byte(s), &v); err == nil {
+				return v
+			}
+		}
+		return value
+	}
+
+	if t, ok := value.(time.Time); ok {
+		if col.Type == iop.DateType {
+			return t.Format("2006-01-02")
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return cast.ToString(value)
+}
+
+// gqlComparisons maps the comparison input fields to the filter operators
+var gqlComparisons = map[string]FilterOperator{
+	"eq":    FilterOpEq,
+	"neq":   FilterOpNeq,
+	"gt":    FilterOpGt,
+	"gte":   FilterOpGte,
+	"lt":    FilterOpLt,
+	"lte":   FilterOpLte,
+	"like":  FilterOpLike,
+	"ilike": FilterOpIlike,
+	"in":    FilterOpIn,
+	"nin":   FilterOpIn,
+}
+
+// where converts the `where` argument to a filter group
+func (ex *gqlExecutor) where(t *gqlTable, value any) (filter *Filter, err error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, g.Error("expected an object of type %s_filter", t.Name)
+	}
+
+	group := Filter{Group: "and"}
+	names := lo.Keys(object)
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := object[name]
+		switch name {
+		case "and", "or":
+			items, ok := value.([]any)
+			if !ok {
+				items = []any{value}
+			}
+			child := Filter{Group: name}
+			for _, item := range items {
+				itemFilter, err := ex.where(t, item)
+				if err != nil {
+					return nil, err
+				} else if itemFilter != nil {
+					child.Children = append(child.Children, *itemFilter)
+				}
+			}
+			if len(child.Children) > 0 {
+				group.Children = append(group.Children, child)
+			}
+		case "not":
+			child, err := ex.where(t, value)
+			if err != nil {
+				return nil, err
+			} else if child != nil {
+				child.Negate = !child.Negate
+				group.Children = append(group.Children, *child)
+			}
+		default:
+			col, ok := t.Fields[name]
+			if !ok {
+				return nil, g.Error("field %s not found on type %s_filter", name, t.Name)
+			}
+
+			comparisons, ok := value.(map[string]any)
+			if !ok {
+				return nil, g.Error("expected an object of type %s_comparison for %s", gqlScalar(col), name)
+			}
+
+			for _, op := range lo.Keys(comparisons) {
+				child, err := gqlComparison(col, op, comparisons[op])
+				if err != nil {
+					return nil, g.Error(err, "invalid comparison for %s", name)
+				} else if child != nil {
+					group.Children = append(group.Children, *child)
+				}
+			}
+		}
+	}
+
+	if len(group.Children) == 0 {
+		return nil, nil
+	}
+	return &group, nil
+}
+
+// gqlComparison converts a comparison of the column to a filter
+func gqlComparison(col iop.Column, op string, value any) (filter *Filter, err error) {
+	if op == "is_null" {
+		if value == nil {
+			return nil, nil
+		}
+		filter = &Filter{Column: col.Name, Operator: FilterOpIs, Values: []string{"null"}}
+		filter.Negate = !cast.ToBool(value)
+		return filter, nil
+	}
+
+	operator, ok := gqlComparisons[op]
+	if !ok {
+		return nil, g.Error("unknown operator %s", op)
+	} else if value == nil {
+		return nil, nil // null is ignored, as an omitted field
+	} else if (operator == FilterOpLike || operator == FilterOpIlike) && gqlScalar(col) != "String" {
+		return nil, g.Error("operator %s only applies to String fields", op)
+	} else if gqlScalar(col) == "JSON" {
+		return nil, g.Error("operator %s does not apply to JSON fields", op)
+	}
+
+	values := []string{}
+	if operator == FilterOpIn {
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if item == nil {
+				return nil, g.Error("%s does not accept null values", op)
+			}
+			values = append(values, gqlFilterValue(item))
+		}
+
+		if len(values) == 0 {
+			if op == "nin" {
+				return nil, nil
+			}
+			// nothing is in an empty list
+			isNull := Filter{Column: col.Name, Operator: FilterOpIs, Values: []string{"null"}}
+			isNotNull := isNull
+			isNotNull.Negate = true
+			return &Filter{Group: "and", Children: []Filter{isNull, isNotNull}}, nil
+		}
+	} else {
+		if _, ok := value.([]any); ok {
+			return nil, g.Error("%s does not accept a list", op)
+		}
+		values = append(values, gqlFilterValue(value))
+	}
+
+	return &Filter{Column: col.Name, Operator: operator, Values: values, Negate: op == "nin"}, nil
+}
+
+// gqlFilterValue returns the filter value of the argument value
+func gqlFilterValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int64(v)) {
+			return cast.ToString(int64(v))
+		}
+	case map[string]any:
+		return g.Marshal(v)
+	}
+	return cast.ToString(value)
+}
+
+// orderBy converts the `order_by` argument. Each item must have one field,
+// since the order of the fields in an object is not significant.
+func (ex *gqlExecutor) orderBy(t *gqlTable, value any) (orders []OrderBy, err error) {
+	items, ok := value.([]any)
+	if !ok {
+		items = []any{value}
+	}
+
+	for _, item := range items {
+		object, ok := item.(map[string]any)
+		if !ok {
+			return nil, g.Error("expected an object of type %s_order_by", t.Name)
+		} else if len(object) != 1 {
+			return nil, g.Error("each %s_order_by item must have exactly one field, use a list to order by several fields", t.Name)
+		}
+
+		for name, direction := range object {
+			col, ok := t.Fields[name]
+			if !ok {
+				return nil, g.Error("field %s not found on type %s_order_by", name, t.Name)
+			}
+
+			order := OrderBy{Column: col.Name}
+			switch cast.ToString(direction) {
+			case "asc":
+			case "asc_nulls_first":
+				order.Nulls = "first"
+			case "asc_nulls_last":
+				order.Nulls = "last"
+			case "desc":
+				order.Desc = true
+			case "desc_nulls_first":
+				order.Desc, order.Nulls = true, "first"
+			case "desc_nulls_last":
+				order.Desc, order.Nulls = true, "last"
+			default:
+				return nil, g.Error("invalid order_direction for %s: %v", name, direction)
+			}
+			orders = append(orders, order)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// HealthRoutes are the liveness and readiness routes, which need the server
+func (s *Server) HealthRoutes() []echo.Route {
+	return []echo.Route{
+		{
+			Name:    "getHealth",
+			Method:  "GET",
+			Path:    "/.health",
+			Handler: s.getHealth,
+		},
+		{
+			Name:    "getReady",
+			Method:  "GET",
+			Path:    "/.ready",
+			Handler: s.getReady,
+		},
+	}
+}
+
+// HealthStatus is the payload of the health and readiness routes
+type HealthStatus struct {
+	Status          string                      `json:"status"` // ok or down
+	Version         string                      `json:"version"`
+	Uptime          float64                     `json:"uptime_seconds"`
+	InFlightQueries int                         `json:"in_flight_queries"`
+	Files           map[string]string           `json:"files"`
+	Connections     map[string]ConnectionHealth `json:"connections,omitempty"`
+}
+
+// ConnectionHealth is the reachability of a connection
+type ConnectionHealth struct {
+	Status   string `json:"status"` // ok or down
+	Required bool   `json:"required"`
+	Latency  int64  `json:"latency_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+// getHealth is the liveness probe: the process is up and serving.
+// It does not check the connections.
+func (s *Server) getHealth(c echo.Context) (err error) {
+	health := s.healthStatus()
+	if !healthDetails(c) {
+		for name, fileStatus := range health.Files {
+			health.Files[name] = lo.Ternary(fileStatus == "ok", "ok", "invalid")
+		}
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
+// readyCache holds the last connection checks, so that probes
+// do not ping the databases more than every readyCacheTTL
+var readyCache struct {
+	connections map[string]ConnectionHealth
+	checked     time.Time
+	mux         sync.Mutex
+}
+
+const readyCacheTTL = 5 * time.Second
+
+// getReady is the readiness probe: the roles and tokens files are valid,
+// and the required connections (DBREST_READY_CONNECTIONS, all by default)
+// are reachable within DBREST_READY_TIMEOUT (5s by default). The details
+// (connection names and errors) are only returned with the bearer token
+// DBREST_HEALTH_TOKEN.
+func (s *Server) getReady(c echo.Context) (err error) {
+	health := s.healthStatus()
+	health.Connections = cachedConnections()
+
+	for _, fileStatus := range health.Files {
+		if fileStatus != "ok" {
+			health.Status = "down"
+		}
+	}
+	for _, conn := range health.Connections {
+		if conn.Required && conn.Status != "ok" {
+			health.Status = "down"
+		}
+	}
+
+	if !healthDetails(c) {
+		health.Connections = nil
+		for name, fileStatus := range health.Files {
+			health.Files[name] = lo.Ternary(fileStatus == "ok", "ok", "invalid")
+		}
+	}
+
+	if health.Status != "ok" {
+		return c.JSON(http.StatusServiceUnavailable, health)
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
+// healthDetails returns true if the request has the bearer token
+// DBREST_HEALTH_TOKEN, which must be set
+func healthDetails(c echo.Context) bool {
+	token := os.Getenv("DBREST_HEALTH_TOKEN")
+	return token != "" && matchBearerToken(c, token)
+}
+
+// cachedConnections returns the connection checks, refreshed every
+// readyCacheTTL. Concurrent probes wait for the same check.
+func cachedConnections() map[string]ConnectionHealth {
+	readyCache.mux.Lock()
+	defer readyCache.mux.Unlock()
+
+	if readyCache.connections == nil || time.Since(readyCache.checked) > readyCacheTTL {
+		readyCache.connections = checkConnections()
+		readyCache.checked = time.Now()
+	}
+
+	connections := map[string]ConnectionHealth{}
+	for name, health := range readyCache.connections {
+		connections[name] = health
+	}
+	return connections
+}
+
+func (s *Server) healthStatus() (health HealthStatus) {
+	health = HealthStatus{
+		Status:  "ok",
+		Version: state.Version,
+		Uptime:  time.Since(s.StartTime).Seconds(),
+		Files:   map[string]string{},
+	}
+
+	for _, counts := range state.QueryCounts() {
+		health.InFlightQueries += counts[state.QueryStatusSubmitted]
+	}
+
+	if project := state.DefaultProject(); project != nil {
+		rolesErr, tokensErr := project.CheckFiles()
+		health.Files["roles"] = lo.Ternary(rolesErr == nil, "ok", g.ErrMsgSimple(rolesErr))
+		health.Files["tokens"] = lo.Ternary(tokensErr == nil, "ok", g.ErrMsgSimple(tokensErr))
+	}
+
+	return
+}
+
+// checkConnections checks the connections of the default project in parallel
+func checkConnections() (connections map[string]ConnectionHealth) {
+	connections = map[string]ConnectionHealth{}
+
+	project := state.DefaultProject()
+	if project == nil {
+		return
+	}
+
+	timeout := 5 * time.Second
+	if val := cast.ToInt(os.Getenv("DBREST_READY_TIMEOUT")); val > 0 {
+		timeout = time.Duration(val) * time.Second
+	}
+
+	names := project.ConnectionNames()
+
+	required := lo.Map(strings.Split(os.Getenv("DBREST_READY_CONNECTIONS"), ","), func(name string, i int) string {
+		return strings.ToLower(strings.TrimSpace(name))
+	})
+	required = lo.Filter(required, func(name string, i int) bool { return name != "" })
+	for _, name := range required {
+		if !lo.Contains(names, name) {
+			connections[name] = ConnectionHealth{Status: "down", Required: true, Error: "connection not found"}
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := project.CheckConnection(name, timeout)
+			health := ConnectionHealth{
+				Status:   lo.Ternary(err == nil, "ok", "down"),
+				Required: len(required) == 0 || lo.Contains(required, name),
+				Latency:  time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				health.Error = g.ErrMsgSimple(err)
+			}
+
+			mux.Lock()
+			connections[name] = health
+			mux.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	return
+}
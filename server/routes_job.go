@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+)
+
+// postJob submits the SQL of the body as a job, and returns it right away
+// with a 202 status. The result set is spooled to disk, to be downloaded
+// from `/.jobs/:id/result`.
+func postJob(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	// read query text
+	body, _ := io.ReadAll(c.Request().Body)
+	req.Query = string(body)
+
+	if err = req.Validate(reqCheckConnection, reqCheckQuery); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if !req.Roles.CanSQL(req.Connection) {
+		if !req.Roles.CanSelectSQL(req.Connection) {
+			return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to submit custom SQL"))
+		} else if err = checkSelectSQL(req); err != nil {
+			return g.ErrJSON(http.StatusForbidden, err)
+		}
+	}
+
+	query := req.Project.NewQuery(context.Background())
+	query.ID = g.NewTsID("job")
+	query.Conn = req.Connection
+	query.Database = req.Database
+	query.Text = req.Query
+	query.Token = req.Token
+	query.ReadOnly = !req.Roles.CanSQL(req.Connection)
+	req.echoCtx.Set("query", query)
+
+	job, err := req.Project.SubmitJob(query)
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not submit job")
+	}
+
+	resp.Status = http.StatusAccepted
+	resp.Payload = g.ToMap(job.State())
+	resp.Header.Set("X-Request-ID", job.ID)
+	resp.Header.Set("Location", g.F("/%s/.jobs/%s", req.Connection, job.ID))
+	return resp.Make()
+}
+
+// getJob returns the status and progress of the job
+func getJob(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	job, err := getRequestJob(req)
+	if err != nil {
+		return err
+	}
+
+	info := job.State()
+	resp.Payload = g.ToMap(info)
+	resp.Payload["elapsed"] = time.Now().Unix() - info.Start // seconds
+	if info.End > 0 {
+		resp.Payload["elapsed"] = info.End - info.Start
+	}
+	resp.Header.Set("X-Request-Status", string(info.Status))
+	return resp.Make()
+}
+
+// getJobResult downloads the spooled result set of a completed job,
+// as a gzipped CSV file. Range requests are supported.
+func getJobResult(c echo.Context) (err error) {
+	req := NewRequest(c)
+
+	job, err := getRequestJob(req)
+	if err != nil {
+		return err
+	}
+
+	info := job.State()
+	c.Response().Header().Set("X-Request-Status", string(info.Status))
+	switch {
+	case info.Status == state.QueryStatusSubmitted:
+		return g.ErrJSON(http.StatusConflict, g.Error("job %s is still running", info.ID))
+	case info.Status != state.QueryStatusCompleted:
+		return g.ErrJSON(http.StatusNotFound, g.Error("job %s has no result: %s", info.ID, info.Status))
+	case info.Affected != -1:
+		return g.ErrJSON(http.StatusNotFound, g.Error("job %s has no result set (%d rows affected)", info.ID, info.Affected))
+	}
+
+	file, err := os.Open(info.File)
+	if err != nil {
+		return ErrJSON(http.StatusNotFound, err, "could not open job result")
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not open job result")
+	}
+
+	c.Set("rows", info.Rows) // for middleware
+	c.Response().Header().Set("Content-Type", "application/gzip")
+	c.Response().Header().Set("Content-Disposition", g.F(`attachment; filename="%s"`, path.Base(info.File)))
+	c.Response().Header().Set("X-Request-Columns", g.Marshal(info.Columns))
+	http.ServeContent(c.Response(), c.Request(), path.Base(info.File), stat.ModTime(), file)
+	return nil
+}
+
+// deleteJob cancels a running job, or removes a finished job and its result
+func deleteJob(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	job, err := getRequestJob(req)
+	if err != nil {
+		return err
+	}
+
+	if job.State().Status == state.QueryStatusSubmitted {
+		if err = job.Cancel(); err != nil {
+			return ErrJSON(http.StatusInternalServerError, err, "could not cancel job")
+		}
+	} else {
+		job.Remove(req.Project)
+	}
+
+	resp.Payload = g.ToMap(job.State())
+	return resp.Make()
+}
+
+// getRequestJob returns the job of the request. Jobs are visible to the
+// tokens which may submit any SQL, else only to the submitting token.
+func getRequestJob(req *Request) (job *state.Job, err error) {
+	if err = req.Validate(reqCheckConnection, reqCheckID); err != nil {
+		return nil, ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if !req.Roles.CanSelectSQL(req.Connection) {
+		return nil, g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to access jobs"))
+	}
+
+	job, err = req.Project.GetJob(req.ID)
+	if err != nil {
+		return nil, g.ErrJSON(http.StatusNotFound, err)
+	}
+
+	info := job.State()
+	if info.Conn != req.Connection {
+		return nil, g.ErrJSON(http.StatusNotFound, g.Error("job %s not found", req.ID))
+	} else if !req.Roles.CanSQL(req.Connection) && (req.Token == "" || info.Token != req.Token) {
+		return nil, g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to access job %s", req.ID))
+	}
+
+	return job, nil
+}
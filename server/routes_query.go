@@ -4,12 +4,17 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dbrest-io/dbrest/state"
 	"github.com/flarco/g"
 	"github.com/labstack/echo/v5"
 	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
 	"github.com/spf13/cast"
 )
 
@@ -21,13 +26,315 @@ func postConnectionSQL(c echo.Context) (err error) {
 	body, _ := io.ReadAll(c.Request().Body)
 	req.Query = string(body)
 
-	if !req.Roles.CanSQL(req.Connection) {
+	if req.Roles.CanSQL(req.Connection) {
+		return processQueryRequest(req)
+	} else if !req.Roles.CanSelectSQL(req.Connection) {
 		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to submit custom SQL"))
 	}
 
+	// only_select: the query must be read-only, on readable tables
+	if err = req.Validate(reqCheckConnection, reqCheckQuery); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if err = checkSelectSQL(req); err != nil {
+		return g.ErrJSON(http.StatusForbidden, err)
+	}
+	req.readOnly = true
+
 	return processQueryRequest(req)
 }
 
+// checkSelectSQL verifies that the query is read-only, and that every
+// referenced table is readable without column or row restrictions
+func checkSelectSQL(req *Request) (err error) {
+	class := state.ClassifySQL(req.Query)
+	if !class.ReadOnly {
+		return g.Error("Not allowed to submit non read-only SQL: %s", class.Reason)
+	}
+
+	for _, name := range class.Tables {
+		table, err := database.ParseTableName(name, req.conn.Type)
+		if err != nil {
+			return g.Error(err, "could not parse table name: %s", name)
+		}
+
+		if table.Schema == "" {
+			if table.Schema = defaultSchema(req); table.Schema == "" {
+				return g.Error("table %s must be qualified with its schema", name)
+			}
+		}
+
+		if !req.CanRead(table) {
+			return g.Error("Not allowed to read table %s", table.FullName())
+		} else if req.ColumnPerms[state.OperationSelect].Restricted(req.Permissions.Objects(table)) {
+			return g.Error("Not allowed to query table %s with SQL, columns are restricted", table.FullName())
+		} else if rowFilters, err := req.RowFilters(state.OperationSelect, table); err != nil || len(rowFilters) > 0 {
+			return g.Error("Not allowed to query table %s with SQL, rows are restricted", table.FullName())
+		}
+	}
+
+	return nil
+}
+
+// defaultSchema returns the schema of unqualified table names
+func defaultSchema(req *Request) string {
+	conn, err := req.Project.GetConnInstance(req.Connection, req.Database)
+	if err == nil && conn.GetProp("schema") != "" {
+		return conn.GetProp("schema")
+	}
+
+	switch req.conn.Type {
+	case dbio.TypeDbPostgres, dbio.TypeDbRedshift:
+		return "public"
+	case dbio.TypeDbSQLite, dbio.TypeDbDuckDb, dbio.TypeDbMotherDuck:
+		return "main"
+	case dbio.TypeDbSQLServer, dbio.TypeDbAzure, dbio.TypeDbAzureDWH:
+		return "dbo"
+	}
+	return ""
+}
+
+// getSavedQuery runs a saved query, with the parameters of the query string.
+// When continuing, the `id` query param is the query ID, not a parameter.
+func getSavedQuery(c echo.Context) (err error) {
+	cont := c.Request().Header.Get("X-Request-Continue") != ""
+
+	values := map[string]any{}
+	for key, vals := range c.QueryParams() {
+		if cont && key == "id" {
+			continue
+		}
+		if !strings.HasPrefix(key, ".") && !g.In(key, filterReservedKeys...) && len(vals) > 0 {
+			values[key] = vals[0]
+		}
+	}
+
+	return processSavedQuery(NewRequest(c), values)
+}
+
+// postSavedQuery runs a saved query, with the parameters of the JSON body
+func postSavedQuery(c echo.Context) (err error) {
+	req := NewRequest(c)
+
+	values := map[string]any{}
+	body, _ := io.ReadAll(c.Request().Body)
+	if strings.TrimSpace(string(body)) != "" {
+		decoder := json.NewDecoder(strings.NewReader(string(body)))
+		decoder.UseNumber()
+		if err = decoder.Decode(&values); err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "invalid body, expected a JSON object of parameters")
+		}
+	}
+
+	return processSavedQuery(req, values)
+}
+
+// processSavedQuery renders the saved query with the parameters as bind
+// values, and submits it. Access is granted by the roles of the saved
+// query, regardless of `allow_sql`.
+func processSavedQuery(req *Request, values map[string]any) (err error) {
+	if err = req.Validate(reqCheckConnection, reqCheckName); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	}
+
+	savedQuery, ok := req.Project.SavedQueries[strings.ToLower(req.Name)]
+	if !ok || !savedQuery.AllowedConnection(req.Connection) {
+		return g.ErrJSON(http.StatusNotFound, g.Error("saved query %s not found", req.Name))
+	} else if !savedQuery.Allowed(req.Roles) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to run saved query %s", req.Name))
+	}
+
+	conn, err := req.Project.GetConnInstance(req.Connection, req.Database)
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not get conn %s", req.Connection)
+	}
+
+	fr := NewFilterRenderer(conn, nil)
+	req.Query, err = savedQuery.Render(values, fr.Arg)
+	if err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid parameters")
+	}
+	req.args = fr.Args
+	req.savedQuery = strings.ToLower(req.Name)
+
+	// the id query param may be a parameter, only used to continue
+	if req.Header.Get("X-Request-Continue") == "" {
+		req.ID = ""
+	}
+
+	return processQueryRequest(req)
+}
+
+// getQueryHistory returns the recorded queries of the connection, latest
+// first. Tokens which may not submit any SQL only see their own queries.
+func getQueryHistory(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	if err = req.Validate(reqCheckConnection); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if !req.Roles.CanSelectSQL(req.Connection) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to view the query history"))
+	} else if req.Project.History == nil {
+		return g.ErrJSON(http.StatusNotFound, g.Error("query history is disabled"))
+	}
+
+	filter := state.QueryHistoryFilter{
+		Conn:  req.Connection,
+		Limit: cast.ToInt(c.QueryParam("limit")),
+	}
+	if !req.Roles.CanSQL(req.Connection) {
+		if req.Token == "" {
+			return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to view the query history"))
+		}
+		filter.Token = req.Token
+	}
+
+	for _, status := range strings.Split(c.QueryParam("status"), ",") {
+		if status = strings.TrimSpace(strings.ToLower(status)); status != "" {
+			filter.Status = append(filter.Status, state.QueryStatus(status))
+		}
+	}
+
+	if filter.Since, err = parseTimeParam(c.QueryParam("since")); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid since value")
+	} else if filter.Until, err = parseTimeParam(c.QueryParam("until")); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid until value")
+	}
+
+	queries, err := req.Project.History.List(req.Project.ID, filter)
+	if err != nil {
+		return ErrJSON(http.StatusInternalServerError, err, "could not get query history")
+	}
+
+	columns := iop.Columns{
+		{Name: "id", Type: iop.StringType},
+		{Name: "database", Type: iop.StringType},
+		{Name: "text", Type: iop.TextType},
+		{Name: "status", Type: iop.StringType},
+		{Name: "start", Type: iop.TimestampType},
+		{Name: "end", Type: iop.TimestampType},
+		{Name: "duration", Type: iop.BigIntType}, // seconds
+		{Name: "error", Type: iop.TextType},
+		{Name: "affected", Type: iop.BigIntType},
+		{Name: "token", Type: iop.StringType},
+	}
+	resp.data = iop.NewDataset(columns)
+	for _, query := range queries {
+		var end, duration any
+		if query.End > 0 {
+			end = time.Unix(query.End, 0).UTC()
+			duration = query.End - query.Start
+		}
+
+		resp.data.Append([]any{
+			query.ID,
+			query.Database,
+			query.Text,
+			string(query.Status),
+			time.Unix(query.Start, 0).UTC(),
+			end,
+			duration,
+			query.Err,
+			lo.Ternary[any](query.Affected == -1, nil, query.Affected),
+			query.Token,
+		})
+	}
+
+	return resp.Make()
+}
+
+// getActiveQueries returns the in-flight queries of the connection
+func getActiveQueries(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	if err = req.Validate(reqCheckConnection); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if !req.Roles.CanSelectSQL(req.Connection) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to view the active queries"))
+	}
+
+	resp.data = activeQueries(req, func(query *state.Query) bool {
+		return query.Conn == req.Connection
+	})
+
+	return resp.Make()
+}
+
+// getAllActiveQueries returns the in-flight queries of all the connections
+// visible to the token
+func getAllActiveQueries(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	if err = req.Validate(); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	}
+
+	resp.data = activeQueries(req, func(query *state.Query) bool { return true })
+
+	return resp.Make()
+}
+
+// activeQueries returns the running queries matching the filter. Tokens
+// which may not submit any SQL on a connection only see their own queries.
+func activeQueries(req *Request, filter func(query *state.Query) bool) (data iop.Dataset) {
+	columns := iop.Columns{
+		{Name: "id", Type: iop.StringType},
+		{Name: "connection", Type: iop.StringType},
+		{Name: "database", Type: iop.StringType},
+		{Name: "text", Type: iop.TextType},
+		{Name: "status", Type: iop.StringType},
+		{Name: "start", Type: iop.TimestampType},
+		{Name: "elapsed", Type: iop.BigIntType}, // seconds
+		{Name: "token", Type: iop.StringType},
+	}
+	data = iop.NewDataset(columns)
+
+	now := time.Now()
+	for _, query := range req.Project.ActiveQueries() {
+		if query.Status != state.QueryStatusSubmitted {
+			continue // finished, waiting to be fetched
+		} else if !filter(query) || !req.Roles.CanSelectSQL(query.Conn) {
+			continue
+		} else if !req.Roles.CanSQL(query.Conn) && (req.Token == "" || query.Token != req.Token) {
+			continue
+		}
+
+		var start, elapsed any
+		if query.Start > 0 {
+			start = time.Unix(query.Start, 0).UTC()
+			elapsed = now.Unix() - query.Start
+		}
+
+		data.Append([]any{
+			query.ID,
+			query.Conn,
+			query.Database,
+			query.Text,
+			string(query.Status),
+			start,
+			elapsed,
+			query.Token,
+		})
+	}
+
+	return data
+}
+
+// parseTimeParam parses a time query param: a duration before now
+// (e.g. `1h`), a unix timestamp or a date/time
+func parseTimeParam(value string) (t time.Time, err error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return t, nil
+	} else if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	} else if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return cast.ToTimeE(value)
+}
+
 func postConnectionCancel(c echo.Context) (err error) {
 
 	req := NewRequest(c)
@@ -35,15 +342,31 @@ func postConnectionCancel(c echo.Context) (err error) {
 
 	if err = req.Validate(reqCheckConnection, reqCheckID); err != nil {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
-	} else if !req.Roles.CanSQL(req.Connection) {
+	} else if !req.Roles.CanSelectSQL(req.Connection) {
 		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to cancel"))
 	}
 
+	// only_select tokens may only cancel their own queries
+	active, ok := lo.Find(req.Project.ActiveQueries(), func(q *state.Query) bool { return q.ID == req.ID })
+	if !ok || active.Conn != req.Connection {
+		return g.ErrJSON(http.StatusNotFound, g.Error("could not find query %s", req.ID))
+	} else if !canAccessQuery(req, active) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to cancel query %s", req.ID))
+	}
+
 	query := req.Project.NewQuery(context.Background())
 	query.Conn = req.Connection
 	query.ID = req.ID
 	req.echoCtx.Set("query", query)
 
+	if job, err := req.Project.GetJob(req.ID); err == nil {
+		err = job.Cancel() // ends the job as cancelled
+		if err != nil {
+			return ErrJSON(http.StatusInternalServerError, err, "could not cancel job")
+		}
+		return resp.Make()
+	}
+
 	err = query.Cancel()
 	if err != nil {
 		return ErrJSON(http.StatusInternalServerError, err, "could not cancel query")
@@ -52,7 +375,28 @@ func postConnectionCancel(c echo.Context) (err error) {
 	return resp.Make()
 }
 
-func processQueryRequest(req Request) (err error) {
+// canAccessQuery returns true if the query may be continued or cancelled:
+// tokens which may not submit any SQL only access their own queries
+func canAccessQuery(req *Request, query *state.Query) bool {
+	if query.Conn != req.Connection {
+		return false
+	}
+	return req.Roles.CanSQL(query.Conn) || (req.Token != "" && query.Token == req.Token)
+}
+
+// canContinueQuery returns true if the query may be continued with the
+// request. Saved queries are only continued through the same saved query,
+// by the token which submitted them.
+func canContinueQuery(req *Request, query *state.Query) bool {
+	if query.Saved != req.savedQuery {
+		return false
+	} else if query.Saved != "" {
+		return query.Conn == req.Connection && query.Token == req.Token
+	}
+	return canAccessQuery(req, query)
+}
+
+func processQueryRequest(req *Request) (err error) {
 	// default ID if not provided
 	req.ID = lo.Ternary(req.ID == "", g.NewTsID("sql"), req.ID)
 
@@ -72,7 +416,12 @@ func processQueryRequest(req Request) (err error) {
 	query.Conn = req.Connection
 	query.Database = req.Database
 	query.Text = req.Query
+	query.Args = req.args
+	query.Columns = req.columns
 	query.ID = req.ID
+	query.Token = req.Token
+	query.ReadOnly = req.readOnly
+	query.Saved = req.savedQuery
 
 	query.Limit = cast.ToInt(req.echoCtx.QueryParam("limit"))
 	if query.Limit == 0 {
@@ -84,7 +433,15 @@ func processQueryRequest(req Request) (err error) {
 	req.echoCtx.Set("query", query)
 	if err != nil {
 		return ErrJSON(http.StatusInternalServerError, err, "could not get process query")
-	} else if query.IsGenerated && !cont {
+	} else if cont && !canContinueQuery(req, query) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to continue query %s", query.ID))
+	} else if cont {
+		if _, err = req.Project.GetJob(query.ID); err == nil {
+			return g.ErrJSON(http.StatusForbidden, g.Error("query %s is a job, see /.jobs/%s", query.ID, query.ID))
+		}
+	}
+
+	if query.IsGenerated && !cont {
 		// send generated query to client
 		status202(query)
 		return resp.Make()
@@ -107,6 +464,16 @@ func processQueryRequest(req Request) (err error) {
 		} else if query.Affected != -1 {
 			resp.Payload = g.M("affected", query.Affected)
 			return resp.Make()
+		} else if ts := req.tableSelect; ts != nil && ts.Cursor != nil && query.Stream != nil {
+			// page is bounded, collect to determine the next cursor
+			data, err := query.Stream.Collect(0)
+			if err != nil {
+				return ErrJSON(http.StatusInternalServerError, err, "could not collect page")
+			}
+			if cursor, ok := ts.NextCursor(data); ok {
+				resp.Header.Set("X-Request-Cursor", EncodeCursor(cursor))
+			}
+			resp.ds = data.Stream()
 		}
 		return resp.MakeStreaming()
 	case <-ticker.C:
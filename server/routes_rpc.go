@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/labstack/echo/v5"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+// Routine is a stored procedure or function, with its input parameters
+type Routine struct {
+	Name   database.Table
+	Type   string   // FUNCTION or PROCEDURE
+	Table  bool     // table-valued function
+	Params []string // input parameter names, in order
+}
+
+// postRoutineCall calls a stored procedure or function with the named
+// arguments of the JSON body, and streams the result set if any
+func postRoutineCall(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	if err = req.Validate(reqCheckConnection, reqCheckSchema, reqCheckProcedure); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	}
+
+	name, err := database.ParseTableName(req.Schema+"."+req.Procedure, req.conn.Type)
+	if err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid procedure name")
+	} else if !req.Can(state.OperationExecute, name) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+	}
+
+	// read the named arguments
+	args := map[string]any{}
+	body, _ := io.ReadAll(c.Request().Body)
+	if strings.TrimSpace(string(body)) != "" {
+		decoder := json.NewDecoder(strings.NewReader(string(body)))
+		decoder.UseNumber()
+		if err = decoder.Decode(&args); err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "invalid body, expected a JSON object of named arguments")
+		}
+	}
+
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		routine, err := GetRoutine(c, name, lo.Keys(args))
+		if err != nil {
+			return data, err
+		}
+
+		fr := NewFilterRenderer(c, nil)
+		sql, err := routine.SQL(fr, args)
+		if err != nil {
+			return data, err
+		}
+
+		// procedures may write, so the call is in a transaction,
+		// committed once the result set is streamed
+		resp.ds, err = streamTableWrite(c, req, sql, fr.Args, nil)
+		if err != nil {
+			return data, g.Error(err, "could not call %s", name.FullName())
+		}
+		return data, nil
+	}
+
+	_, err = ProcessRequest(req, rf)
+	if err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "could not call procedure")
+	} else if resp.ds == nil {
+		return c.NoContent(http.StatusNoContent) // no result set
+	}
+
+	return resp.MakeStreaming()
+}
+
+// routineSQL is the query of the routines matching a schema and a name,
+// with their parameters, for connections with an information_schema
+const routineSQL = `select r.specific_name, r.routine_type, r.data_type,
+	p.parameter_name, p.parameter_mode, p.ordinal_position
+	from information_schema.routines r
+	left join information_schema.parameters p
+		on p.specific_schema = r.specific_schema and p.specific_name = r.specific_name
+	where r.routine_schema = {schema} and r.routine_name = {name}
+	order by r.specific_name, p.ordinal_position`
+
+// GetRoutine returns the routine matching the name. When overloaded,
+// the first routine accepting all the argument names is returned.
+func GetRoutine(conn database.Connection, name database.Table, argNames []string) (routine Routine, err error) {
+	switch conn.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbMySQL, dbio.TypeDbMariaDB, dbio.TypeDbSQLServer, dbio.TypeDbAzure:
+	default:
+		return routine, g.Error("procedures are not supported for %s connections", conn.GetType())
+	}
+
+	fr := &FilterRenderer{Conn: conn} // literals, no binding
+	sql := g.R(routineSQL, "schema", fr.Arg(name.Schema), "name", fr.Arg(name.Name))
+	data, err := conn.Query(sql)
+	if err != nil {
+		return routine, g.Error(err, "could not get routine %s", name.FullName())
+	}
+
+	routines := map[string]*Routine{}
+	specificNames := []string{}
+	for _, rec := range data.Records(true) {
+		specificName := cast.ToString(rec["specific_name"])
+		if routines[specificName] == nil {
+			routines[specificName] = &Routine{
+				Name:  name,
+				Type:  strings.ToUpper(cast.ToString(rec["routine_type"])),
+				Table: strings.EqualFold(cast.ToString(rec["data_type"]), "TABLE"),
+			}
+			specificNames = append(specificNames, specificName)
+		}
+
+		// input parameters only, not the return value (position 0)
+		mode := strings.ToUpper(cast.ToString(rec["parameter_mode"]))
+		paramName := strings.TrimPrefix(cast.ToString(rec["parameter_name"]), "@")
+		if cast.ToInt(rec["ordinal_position"]) > 0 && (mode == "IN" || mode == "INOUT") {
+			routines[specificName].Params = append(routines[specificName].Params, paramName)
+		}
+	}
+
+	if len(specificNames) == 0 {
+		return routine, g.Error("procedure or function %s not found", name.FullName())
+	}
+
+	for _, specificName := range specificNames {
+		candidate := *routines[specificName]
+		accepts := lo.EveryBy(argNames, func(argName string) bool {
+			return candidate.Param(argName) != ""
+		})
+		if accepts {
+			return candidate, nil
+		}
+	}
+
+	return routine, g.Error("%s does not accept the arguments: %s", name.FullName(), strings.Join(argNames, ", "))
+}
+
+// Param returns the parameter matching the argument name, case-insensitive
+func (rt Routine) Param(argName string) string {
+	param, _ := lo.Find(rt.Params, func(param string) bool {
+		return param != "" && strings.EqualFold(param, strings.TrimPrefix(argName, "@"))
+	})
+	return param
+}
+
+// SQL renders the call of the routine with the arguments as bind values.
+// Arguments are passed by name where the database supports it, else by
+// position (omitted parameters are null, or their default on SQL Server).
+func (rt Routine) SQL(fr *FilterRenderer, args map[string]any) (sql string, err error) {
+	values := map[string]string{} // placeholder per parameter
+	for _, param := range rt.Params {
+		for argName, value := range args {
+			if rt.Param(argName) == param {
+				values[param] = fr.Arg(routineArgValue(value))
+			}
+		}
+	}
+
+	named := func(format string) string {
+		exprs := []string{}
+		for _, param := range rt.Params {
+			if value, ok := values[param]; ok {
+				exprs = append(exprs, g.F(format, param, value))
+			}
+		}
+		return strings.Join(exprs, ", ")
+	}
+	positional := func(missing string) string {
+		return strings.Join(lo.Map(rt.Params, func(param string, i int) string {
+			return lo.Ternary(values[param] != "", values[param], missing)
+		}), ", ")
+	}
+
+	conn := fr.Conn
+	name := rt.Name.FullName()
+	switch conn.GetType() {
+	case dbio.TypeDbPostgres:
+		args := named(`"%s" => %s`)
+		if rt.Type == "PROCEDURE" {
+			return g.F("call %s(%s)", name, args), nil
+		}
+		return g.F("select * from %s(%s)", name, args), nil
+	case dbio.TypeDbMySQL, dbio.TypeDbMariaDB:
+		if rt.Type == "PROCEDURE" {
+			return g.F("call %s(%s)", name, positional("null")), nil
+		}
+		return g.F("select %s(%s) as %s", name, positional("null"), conn.Quote("result", false)), nil
+	case dbio.TypeDbSQLServer, dbio.TypeDbAzure:
+		switch {
+		case rt.Type == "PROCEDURE":
+			return strings.TrimSpace(g.F("exec %s %s", name, named("@%s = %s"))), nil
+		case rt.Table:
+			return g.F("select * from %s(%s)", name, positional("default")), nil
+		}
+		return g.F("select %s(%s) as %s", name, positional("default"), conn.Quote("result", false)), nil
+	}
+
+	return "", g.Error("procedures are not supported for %s connections", conn.GetType())
+}
+
+// routineArgValue converts a JSON argument to a bind value.
+// Objects and arrays are passed as JSON text.
+func routineArgValue(value any) any {
+	switch v := value.(type) {
+	case fmt.Stringer: // json.Number
+		if number, err := cast.ToInt64E(v.String()); err == nil {
+			return number
+		}
+		return cast.ToFloat64(v.String())
+	case map[string]any, []any:
+		return g.Marshal(v)
+	}
+	return value
+}
@@ -2,12 +2,14 @@ package server
 
 import (
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/dbrest-io/dbrest/state"
 	"github.com/flarco/g"
 	"github.com/labstack/echo/v5"
 	"github.com/samber/lo"
-	"github.com/slingdata-io/sling-cli/core/dbio"
 	"github.com/slingdata-io/sling-cli/core/dbio/database"
 	"github.com/slingdata-io/sling-cli/core/dbio/iop"
 	"github.com/spf13/cast"
@@ -22,7 +24,7 @@ func getTableColumns(c echo.Context) (err error) {
 
 	resp := NewResponse(req)
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		tableColumns, err := c.GetTableColumns(&req.dbTable)
 		if err != nil {
 			err = g.Error(err, "could not get columns")
@@ -39,8 +41,8 @@ func getTableColumns(c echo.Context) (err error) {
 			{Name: "column_type", Type: iop.BoolType},
 		}
 		data = iop.NewDataset(columns)
-		if req.CanRead(req.dbTable) || req.CanWrite(req.dbTable) {
-			for _, column := range tableColumns {
+		if req.CanAccess(req.dbTable) {
+			for _, column := range req.VisibleColumns(req.dbTable, tableColumns) {
 				row := []any{
 					req.Database,
 					req.Schema,
@@ -82,64 +84,93 @@ func getTableSelect(c echo.Context) (err error) {
 
 	// construct SQL Query
 	{
-		conn, err := req.Project.GetConnObject(req.Connection, "")
+		conn, err := req.Project.GetConnInstance(req.Connection, req.Database)
 		if err != nil {
 			err = ErrJSON(http.StatusNotFound, err, "could not find connection: %s", req.Connection)
 			return err
 		}
 
-		var preOptions, postOptions string
+		tableColumns, err := conn.GetTableColumns(&req.dbTable)
+		if err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "could not get columns for table: %s", req.dbTable.FullName())
+		}
 
-		// TODO: parse fields to ensure no SQL injection
-		var fields []string
-		var limit int
-		whereMap := map[string]string{}
+		// only readable columns can be selected or filtered on
+		readable := req.ReadableColumns(req.dbTable, tableColumns)
+		if len(readable) == 0 {
+			return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+		}
 
+		// rows are restricted by the row filters of the roles
+		rowFilters, err := req.RowFilters(state.OperationSelect, req.dbTable)
+		if err != nil {
+			return g.ErrJSON(http.StatusForbidden, err)
+		}
+
+		ts := &TableSelect{Table: req.dbTable}
+		fr := NewFilterRenderer(conn, readable)
+		fr.RowFilters, fr.Attributes = rowFilters, req.Attributes
+
+		var fields []string
 		for k, v := range req.echoCtx.QueryParams() {
 			switch k {
 			case ".columns":
 				fields = strings.Split(v[0], ",")
 			case ".limit":
-				limit = cast.ToInt(v[0])
-				limit = lo.Ternary(limit == 0, 100, limit)
-			default:
-				whereMap[k] = v[0]
+				ts.Limit = cast.ToInt(v[0])
+				ts.Limit = lo.Ternary(ts.Limit == 0, 100, ts.Limit)
+			case ".offset":
+				ts.Offset = cast.ToInt(v[0])
+			case ".order":
+				ts.Order, err = ParseOrder(v[0])
+				if err != nil {
+					return ErrJSON(http.StatusBadRequest, err, "invalid order")
+				}
+			case ".cursor":
+				cursor, err := DecodeCursor(v[0])
+				if err != nil {
+					return ErrJSON(http.StatusBadRequest, err, "invalid cursor")
+				}
+				ts.Cursor = &cursor
 			}
 		}
 
-		makeWhere := func() (ws string) {
-			arr := []string{}
-			for k, v := range whereMap {
-				expr := g.F("%s=%s", k, v)
-				arr = append(arr, expr)
+		ts.Filters, err = ParseFilters(req.echoCtx.QueryParams())
+		if err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "invalid filter")
+		}
+
+		// validate selected fields
+		if noFields := len(fields) == 0 || (len(fields) == 1 && fields[0] == ""); !noFields {
+			for _, field := range fields {
+				col, err := fr.Column(field)
+				if err != nil {
+					return ErrJSON(http.StatusBadRequest, err, "invalid columns")
+				}
+				ts.Fields = append(ts.Fields, col)
 			}
-			// TODO: SQL Injection is possible, need to use bind vars
-			return strings.Join(arr, " and ")
+		} else if len(readable) < len(tableColumns) {
+			ts.Fields = readable // do not select restricted columns
 		}
 
-		if limit > 0 { // For unlimited, specify -1
-			switch conn.Type {
-			case dbio.TypeDbSQLServer:
-				preOptions = preOptions + g.F("top %d", limit)
-			default:
-				postOptions = postOptions + g.F("limit %d", limit)
+		if ts.Cursor != nil {
+			if err = ts.prepareKeyset(conn, fr); err != nil {
+				return ErrJSON(http.StatusBadRequest, err, "invalid cursor paging")
 			}
+			req.tableSelect = ts
+		}
 
+		if ts.Limit > 0 { // For unlimited, specify -1
 			// set for processQueryRequest
-			req.echoCtx.QueryParams().Set("limit", cast.ToString(limit))
+			req.echoCtx.QueryParams().Set("limit", cast.ToString(ts.Limit))
 		}
 
-		noFields := len(fields) == 0 || (len(fields) == 1 && fields[0] == "")
-		noWhere := len(whereMap) == 0
-
-		req.Query = g.R(
-			"select{preOptions} {fields} from {table} where {where} {postOptions}",
-			"fields", lo.Ternary(noFields, "*", strings.Join(fields, ", ")),
-			"table", req.dbTable.FullName(),
-			"where", lo.Ternary(noWhere, "1=1", makeWhere()),
-			"preOptions", lo.Ternary(preOptions != "", " "+preOptions, ""),
-			"postOptions", lo.Ternary(postOptions != "", " "+postOptions, ""),
-		)
+		req.Query, err = ts.SQL(fr)
+		if err != nil {
+			return ErrJSON(http.StatusBadRequest, err, "could not construct query")
+		}
+		req.args = fr.Args
+		req.columns = lo.Ternary(len(ts.Fields) > 0, ts.Fields, readable)
 	}
 
 	return processQueryRequest(req)
@@ -154,7 +185,7 @@ func getTableIndexes(c echo.Context) (err error) {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		return c.GetIndexes(req.dbTable.FullName())
 	}
 
@@ -175,7 +206,7 @@ func getTableKeys(c echo.Context) (err error) {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 		return c.GetPrimaryKeys(req.dbTable.FullName())
 	}
 
@@ -194,13 +225,29 @@ func postTableInsert(c echo.Context) (err error) {
 
 	if err = req.Validate(reqCheckConnection, reqCheckSchema, reqCheckTable); err != nil {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
-	} else if !req.CanWrite(req.dbTable) {
+	} else if !req.Can(state.OperationInsert, req.dbTable) {
 		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+	} else if req.ReturnRepresentation() && !req.CanRead(req.dbTable) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to read the written rows"))
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rowFilters, err := req.RowFilters(state.OperationInsert, req.dbTable)
+	if err != nil {
+		return g.ErrJSON(http.StatusForbidden, err)
+	}
+
+	var bulkFailure map[string]any // progress of a failed bulk load
+
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
 
 		bulk := req.echoCtx.QueryParam(".bulk")
+		bulk = lo.Ternary(bulk == "", req.echoCtx.QueryParam("bulk"), bulk)
+
+		tableColumns, err := c.GetTableColumns(&req.dbTable)
+		if err != nil {
+			err = g.Error(err, "could not get columns")
+			return
+		}
 
 		ds, err := req.GetDatastream()
 		if err != nil {
@@ -208,14 +255,52 @@ func postTableInsert(c echo.Context) (err error) {
 			return
 		}
 
-		if req.echoCtx.QueryParam("bulk") == "true" {
-			_ = bulk
-			// TODO: bulk loading option
-			// df, err := iop.MakeDataFlow(ds.Split()...)
-			// if err != nil {
-			// 	err = g.Error(err, "could not make dataflow")
-			// 	return
-			// }
+		// only writable columns are accepted
+		fr := NewFilterRenderer(c, req.WritableColumns(state.OperationInsert, req.dbTable, tableColumns))
+		columns, err := matchStreamColumns(fr, ds)
+		if err != nil {
+			return
+		}
+
+		// the inserted rows must match the row filters, so they are
+		// collected and checked before the insert
+		if len(rowFilters) > 0 {
+			ds, err = checkInsertRowFilters(c, req, ds, columns, rowFilters)
+			if err != nil {
+				return data, err
+			}
+		}
+
+		if cast.ToBool(bulk) {
+			start := time.Now()
+			batchSize := cast.ToInt(req.echoCtx.QueryParam(".batch_size"))
+			count, batches, err := bulkLoadStream(c, req.dbTable, ds, batchSize)
+			if err != nil {
+				// the batches before the failed one are committed
+				bulkFailure = g.M("affected", count, "batches", batches, "failed_batch", batches+1)
+				return data, g.Error(err, "could not bulk load into table (%d rows committed)", count)
+			}
+
+			elapsed := time.Since(start)
+			resp.Payload = g.M(
+				"affected", count,
+				"batches", batches,
+				"elapsed", elapsed.Seconds(),
+				"rows_per_sec", lo.Ternary(elapsed.Seconds() > 0, float64(count)/elapsed.Seconds(), 0),
+			)
+			return data, nil
+		}
+
+		if req.ReturnRepresentation() {
+			readable := req.ReadableColumns(req.dbTable, tableColumns)
+			data, err = insertReturning(c, req, fr, ds, readable)
+			if err != nil {
+				return data, err
+			}
+
+			resp.Header.Set("Preference-Applied", "return=representation")
+			resp.ds = data.Stream()
+			return data, nil
 		}
 
 		ctx := req.echoCtx.Request().Context()
@@ -227,6 +312,7 @@ func postTableInsert(c echo.Context) (err error) {
 
 		count, err := c.InsertBatchStream(req.dbTable.FullName(), ds)
 		if err != nil {
+			c.Rollback()
 			err = g.Error(err, "could not insert into table")
 			return
 		}
@@ -243,9 +329,15 @@ func postTableInsert(c echo.Context) (err error) {
 	}
 
 	_, err = ProcessRequest(req, rf)
-	if err != nil {
+	if err != nil && bulkFailure != nil {
+		g.LogError(err)
+		bulkFailure["error"] = g.F("could not get process request [%s]", g.ErrMsg(err))
+		return echo.NewHTTPError(http.StatusBadRequest, bulkFailure)
+	} else if err != nil {
 		err = ErrJSON(http.StatusBadRequest, err, "could not get process request")
 		return
+	} else if resp.ds != nil {
+		return resp.MakeStreaming()
 	}
 
 	return resp.Make()
@@ -254,53 +346,73 @@ func postTableInsert(c echo.Context) (err error) {
 func postTableUpsert(c echo.Context) (err error) {
 	req := NewRequest(c)
 	resp := NewResponse(req)
-	resp.Status = http.StatusNotImplemented
-	resp.Payload = g.M("error", "Not-Implemented")
-	return resp.Make()
 
 	if err = req.Validate(reqCheckConnection, reqCheckSchema, reqCheckTable); err != nil {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
-	} else if !req.CanWrite(req.dbTable) {
+	} else if !req.Can(state.OperationUpsert, req.dbTable) {
 		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+	} else if rowFilters, err := req.RowFilters(state.OperationUpsert, req.dbTable); err != nil || len(rowFilters) > 0 {
+		// the update part of an upsert could change rows outside the row filters
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to upsert on a row-filtered table"))
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		tableColumns, err := c.GetTableColumns(&req.dbTable)
+		if err != nil {
+			err = g.Error(err, "could not get columns")
+			return
+		}
 
-		bulk := req.echoCtx.QueryParam("bulk")
+		// only writable columns are accepted
+		fr := NewFilterRenderer(c, req.WritableColumns(state.OperationUpsert, req.dbTable, tableColumns))
+
+		// determine key columns
+		var keys []string
+		if onConflict := req.echoCtx.QueryParam(".on_conflict"); onConflict != "" {
+			keys = strings.Split(onConflict, ",")
+		} else {
+			keys, err = getPrimaryKeys(c, req.dbTable)
+			if err != nil {
+				err = g.Error(err, "could not get primary keys")
+				return
+			}
+		}
 
-		ds, err := req.GetDatastream()
-		if err != nil {
-			err = g.Error(err, "could not get datastream")
+		if len(keys) == 0 {
+			err = g.Error("table has no primary key, provide the key columns with `.on_conflict`")
 			return
 		}
 
-		if req.echoCtx.QueryParam("bulk") == "true" {
-			_ = bulk
-			// TODO: bulk loading option
-			// df, err := iop.MakeDataFlow(ds.Split()...)
-			// if err != nil {
-			// 	err = g.Error(err, "could not make dataflow")
-			// 	return
-			// }
+		for i, key := range keys {
+			col, err := fr.Column(key)
+			if err != nil {
+				return data, g.Error(err, "invalid conflict column")
+			}
+			keys[i] = col.Name
 		}
 
-		ctx := req.echoCtx.Request().Context()
-		err = c.BeginContext(ctx)
+		ds, err := req.GetDatastream()
 		if err != nil {
-			err = g.Error(err, "could not begin transaction")
+			err = g.Error(err, "could not get datastream")
 			return
 		}
 
-		// TODO: add c.UpsertBatchStream
-		count, err := c.InsertBatchStream(req.dbTable.FullName(), ds)
+		// stage with the target column names & types
+		stageColumns, err := matchStreamColumns(fr, ds)
 		if err != nil {
-			err = g.Error(err, "could not insert into table")
 			return
 		}
 
-		err = c.Commit()
+		for _, key := range keys {
+			if stageColumns.GetColumn(key) == nil {
+				err = g.Error("missing key column in input: %s", key)
+				return
+			}
+		}
+
+		count, err := upsertStream(c, req, ds, stageColumns, keys)
 		if err != nil {
-			err = g.Error(err, "could not commit transaction")
+			err = g.Error(err, "could not upsert into table")
 			return
 		}
 
@@ -318,66 +430,331 @@ func postTableUpsert(c echo.Context) (err error) {
 	return resp.Make()
 }
 
+// checkInsertRowFilters collects the rows of the stream and checks them
+// against the row filters. Returns a new stream of the collected rows.
+func checkInsertRowFilters(c database.Connection, req *Request, ds *iop.Datastream, columns iop.Columns, rowFilters []string) (*iop.Datastream, error) {
+	data, err := ds.Collect(0)
+	if err != nil {
+		return nil, g.Error(err, "could not read rows")
+	}
+
+	checks, err := insertRowFilterChecks(c, req, columns, data.Rows, rowFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := req.echoCtx.Request().Context()
+	if err = c.BeginContext(ctx); err != nil {
+		return nil, g.Error(err, "could not begin transaction")
+	}
+	err = checkRowFilters(c, req, checks)
+	c.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Stream(), nil
+}
+
+// matchStreamColumns validates the stream columns against the table columns
+// and renames them to the table column names. Returns the matching table columns
+func matchStreamColumns(fr *FilterRenderer, ds *iop.Datastream) (columns iop.Columns, err error) {
+	for i, dsCol := range ds.Columns {
+		col, err := fr.Column(dsCol.Name)
+		if err != nil {
+			return nil, g.Error(err, "invalid input column")
+		}
+		ds.Columns[i].Name = col.Name
+		columns = append(columns, col)
+	}
+	return
+}
+
+// bulkLoadStream loads the stream with the connection's native bulk loader
+// (e.g. COPY for postgres, bcp for sql server). When batchSize is provided,
+// rows are loaded in batches of that size, each batch being committed.
+// On error, count and batches are those of the committed batches.
+func bulkLoadStream(c database.Connection, table database.Table, ds *iop.Datastream, batchSize int) (count uint64, batches int, err error) {
+	if batchSize <= 0 {
+		count, err = c.BulkImportStream(table.FullName(), ds)
+		if err != nil {
+			return 0, 0, err
+		}
+		return count, 1, nil
+	}
+
+	batch := iop.NewDataset(ds.Columns)
+	loadBatch := func() (err error) {
+		batchCount, err := c.BulkImportStream(table.FullName(), batch.Stream())
+		if err != nil {
+			return g.Error(err, "could not load batch %d", batches+1)
+		}
+		count += batchCount
+		batches++
+		batch.Rows = [][]any{}
+		return nil
+	}
+
+	for row := range ds.Rows() {
+		batch.Append(row)
+		if len(batch.Rows) >= batchSize {
+			if err = loadBatch(); err != nil {
+				ds.Context.Cancel()
+				return
+			}
+			g.Debug("bulk loaded %d rows into %s", count, table.FullName())
+		}
+	}
+
+	if err = ds.Err(); err != nil {
+		return
+	}
+
+	if len(batch.Rows) > 0 {
+		if err = loadBatch(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// upsertStream loads the stream into a staging table, then merges it
+// into the target table with the dialect's native upsert form
+// (MERGE, ON CONFLICT or ON DUPLICATE KEY) as rendered by the connection
+func upsertStream(c database.Connection, req *Request, ds *iop.Datastream, columns iop.Columns, keys []string) (count int64, err error) {
+	stageName := g.F("%s.%s_dbrest_%s", req.dbTable.Schema, req.dbTable.Name, strings.ToLower(g.RandString(g.AlphaNumericRunes, 6)))
+	stageTable, err := database.ParseTableName(stageName, c.GetType())
+	if err != nil {
+		err = g.Error(err, "could not parse staging table name")
+		return
+	}
+
+	err = c.CreateTable(stageTable.FullName(), columns, "")
+	if err != nil {
+		err = g.Error(err, "could not create staging table")
+		return
+	}
+	defer func() {
+		if dropErr := c.DropTable(stageTable.FullName()); dropErr != nil {
+			g.LogError(g.Error(dropErr, "could not drop staging table %s", stageTable.FullName()))
+		}
+	}()
+
+	_, err = c.InsertBatchStream(stageTable.FullName(), ds)
+	if err != nil {
+		err = g.Error(err, "could not insert into staging table")
+		return
+	}
+
+	ctx := req.echoCtx.Request().Context()
+	err = c.BeginContext(ctx)
+	if err != nil {
+		err = g.Error(err, "could not begin transaction")
+		return
+	}
+
+	count, err = c.Upsert(stageTable.FullName(), req.dbTable.FullName(), keys)
+	if err != nil {
+		c.Rollback()
+		err = g.Error(err, "could not merge staging table")
+		return
+	}
+
+	err = c.Commit()
+	if err != nil {
+		err = g.Error(err, "could not commit transaction")
+		return
+	}
+
+	return
+}
+
 func patchTableUpdate(c echo.Context) (err error) {
 	req := NewRequest(c)
 	resp := NewResponse(req)
-	resp.Status = http.StatusNotImplemented
-	resp.Payload = g.M("error", "Not-Implemented")
-	return resp.Make()
 
 	if err = req.Validate(reqCheckConnection, reqCheckSchema, reqCheckTable); err != nil {
 		return ErrJSON(http.StatusBadRequest, err, "invalid request")
-	} else if !req.CanWrite(req.dbTable) {
+	} else if !req.Can(state.OperationUpdate, req.dbTable) {
 		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+	} else if req.ReturnRepresentation() && !req.CanRead(req.dbTable) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed to read the written rows"))
 	}
 
-	rf := func(c database.Connection, req Request) (data iop.Dataset, err error) {
-		bulk := req.echoCtx.QueryParam("bulk")
+	// read the column values to set
+	values := map[string]any{}
+	decoder := json.NewDecoder(req.echoCtx.Request().Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(&values); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid body, expected a JSON object of column values")
+	} else if len(values) == 0 {
+		return ErrJSON(http.StatusBadRequest, g.Error("no column values provided"))
+	}
 
-		ds, err := req.GetDatastream()
+	filters, err := ParseFilters(req.echoCtx.QueryParams())
+	if err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid filter")
+	} else if err = checkUnfiltered(req, filters); err != nil {
+		return ErrJSON(http.StatusBadRequest, err)
+	}
+
+	rowFilters, err := req.RowFilters(state.OperationUpdate, req.dbTable)
+	if err != nil {
+		return g.ErrJSON(http.StatusForbidden, err)
+	}
+
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		tableColumns, err := c.GetTableColumns(&req.dbTable)
 		if err != nil {
-			err = g.Error(err, "could not get datastream")
+			err = g.Error(err, "could not get columns")
 			return
 		}
 
-		if req.echoCtx.QueryParam("bulk") == "true" {
-			_ = bulk
-			_ = ds
-			// TODO: bulk loading option
-			// df, err := iop.MakeDataFlow(ds.Split()...)
-			// if err != nil {
-			// 	err = g.Error(err, "could not make dataflow")
-			// 	return
-			// }
+		// only writable columns can be set
+		fr := NewFilterRenderer(c, req.WritableColumns(state.OperationUpdate, req.dbTable, tableColumns))
+		fr.RowFilters, fr.Attributes = rowFilters, req.Attributes
+
+		// sort for deterministic SQL
+		keys := lo.Keys(values)
+		sort.Strings(keys)
+
+		setExprs := []string{}
+		for _, key := range keys {
+			col, err := fr.Column(key)
+			if err != nil {
+				return data, err
+			}
+
+			value, err := fr.BodyValue(col, values[key])
+			if err != nil {
+				return data, err
+			}
+			setExprs = append(setExprs, g.F("%s = %s", c.Quote(col.Name, false), value))
 		}
 
-		ctx := req.echoCtx.Request().Context()
-		err = c.BeginContext(ctx)
+		// filters can only be on readable columns, else the affected count
+		// would reveal hidden values. The bind values continue after the set values
+		fr.Columns = req.ReadableColumns(req.dbTable, tableColumns)
+		where, err := fr.Where(filters)
 		if err != nil {
-			err = g.Error(err, "could not begin transaction")
+			return data, g.Error(err, "invalid filter")
+		}
+
+		// the updated rows must still match the row filters
+		checks := []rowFilterCheck{}
+		if len(rowFilters) > 0 {
+			check, err := updateRowFilterCheck(c, req, tableColumns, values, filters, rowFilters)
+			if err != nil {
+				return data, err
+			}
+			checks = append(checks, check)
+		}
+
+		if req.ReturnRepresentation() {
+			readable := req.ReadableColumns(req.dbTable, tableColumns)
+			output, returning, err := returningClauses(c, "update", readable)
+			if err != nil {
+				return data, err
+			}
+
+			sql := g.R(
+				"update {table} set {set}{output} where {where}{returning}",
+				"table", req.dbTable.FullName(),
+				"set", strings.Join(setExprs, ", "),
+				"output", lo.Ternary(output != "", " "+output, ""),
+				"where", where,
+				"returning", lo.Ternary(returning != "", " "+returning, ""),
+			)
+
+			data, err = queryTableWrite(c, req, sql, fr.Args, readable, checks...)
+			if err != nil {
+				return data, g.Error(err, "could not update table")
+			}
+
+			resp.Header.Set("Preference-Applied", "return=representation")
+			resp.ds = data.Stream()
+			return data, nil
+		}
+
+		sql := g.F(
+			"update %s set %s where %s",
+			req.dbTable.FullName(), strings.Join(setExprs, ", "), where,
+		)
+
+		count, err := execTableWrite(c, req, sql, fr.Args, checks...)
+		if err != nil {
+			err = g.Error(err, "could not update table")
 			return
 		}
 
-		// TODO: add c.UpdateBatchStream
-		count, err := c.InsertBatchStream(req.dbTable.FullName(), ds)
+		resp.Payload = g.M("affected", count)
+
+		return
+	}
+
+	_, err = ProcessRequest(req, rf)
+	if err != nil {
+		err = ErrJSON(http.StatusBadRequest, err, "could not get process request")
+		return
+	} else if resp.ds != nil {
+		return resp.MakeStreaming()
+	}
+
+	return resp.Make()
+}
+
+func deleteTableRows(c echo.Context) (err error) {
+	req := NewRequest(c)
+	resp := NewResponse(req)
+
+	if err = req.Validate(reqCheckConnection, reqCheckSchema, reqCheckTable); err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid request")
+	} else if !req.Can(state.OperationDelete, req.dbTable) {
+		return g.ErrJSON(http.StatusForbidden, g.Error("Not allowed"))
+	}
+
+	filters, err := ParseFilters(req.echoCtx.QueryParams())
+	if err != nil {
+		return ErrJSON(http.StatusBadRequest, err, "invalid filter")
+	} else if err = checkUnfiltered(req, filters); err != nil {
+		return ErrJSON(http.StatusBadRequest, err)
+	}
+
+	rowFilters, err := req.RowFilters(state.OperationDelete, req.dbTable)
+	if err != nil {
+		return g.ErrJSON(http.StatusForbidden, err)
+	}
+
+	rf := func(c database.Connection, req *Request) (data iop.Dataset, err error) {
+		tableColumns, err := c.GetTableColumns(&req.dbTable)
 		if err != nil {
-			err = g.Error(err, "could not insert into table")
+			err = g.Error(err, "could not get columns")
 			return
 		}
 
-		err = c.Commit()
+		// filters can only be on readable columns, see patchTableUpdate
+		fr := NewFilterRenderer(c, req.ReadableColumns(req.dbTable, tableColumns))
+		fr.RowFilters, fr.Attributes = rowFilters, req.Attributes
+		where, err := fr.Where(filters)
 		if err != nil {
-			err = g.Error(err, "could not commit transaction")
+			return data, g.Error(err, "invalid filter")
+		}
+
+		sql := g.F("delete from %s where %s", req.dbTable.FullName(), where)
+
+		count, err := execTableWrite(c, req, sql, fr.Args)
+		if err != nil {
+			err = g.Error(err, "could not delete from table")
 			return
 		}
 
-		data.Columns = iop.Columns{{Name: "affected", Type: iop.IntegerType}}
-		data.Append([]any{count})
+		resp.Payload = g.M("affected", count)
 
 		return
 	}
 
-	resp.data, err = ProcessRequest(req, rf)
+	_, err = ProcessRequest(req, rf)
 	if err != nil {
 		err = ErrJSON(http.StatusBadRequest, err, "could not get process request")
 		return
@@ -385,3 +762,142 @@ func patchTableUpdate(c echo.Context) (err error) {
 
 	return resp.Make()
 }
+
+// queryTableWrite executes the write statement in a transaction, after
+// the row filter checks, and returns the rows returned by the statement
+func queryTableWrite(c database.Connection, req *Request, sql string, args []any, tableColumns iop.Columns, checks ...rowFilterCheck) (data iop.Dataset, err error) {
+	ctx := req.echoCtx.Request().Context()
+	req.echoCtx.Set("sql", sql) // for middleware
+	err = c.BeginContext(ctx)
+	if err != nil {
+		err = g.Error(err, "could not begin transaction")
+		return
+	}
+
+	if err = checkRowFilters(c, req, checks); err != nil {
+		c.Rollback()
+		return
+	}
+
+	data, err = queryRows(c, req, sql, args, tableColumns)
+	if err != nil {
+		c.Rollback()
+		return
+	}
+
+	err = c.Commit()
+	if err != nil {
+		err = g.Error(err, "could not commit transaction")
+		return
+	}
+
+	return
+}
+
+// streamTableWrite executes the statement in a transaction and streams
+// the returned rows. The transaction is committed once all rows are read,
+// and rolled back on error. A nil stream is returned without result set.
+func streamTableWrite(c database.Connection, req *Request, sql string, args []any, tableColumns iop.Columns) (ds *iop.Datastream, err error) {
+	ctx := req.echoCtx.Request().Context()
+	req.echoCtx.Set("sql", sql) // for middleware
+	err = c.BeginContext(ctx)
+	if err != nil {
+		err = g.Error(err, "could not begin transaction")
+		return
+	}
+
+	result, err := c.Tx().QueryContext(ctx, sql, args...)
+	if err != nil {
+		c.Rollback()
+		return nil, g.Error(err, "could not execute statement")
+	}
+
+	fields, err := result.Columns()
+	if err != nil {
+		result.Close()
+		c.Rollback()
+		return nil, g.Error(err, "could not get result columns")
+	} else if len(fields) == 0 {
+		result.Close()
+		if err = c.Commit(); err != nil {
+			return nil, g.Error(err, "could not commit transaction")
+		}
+		return nil, nil
+	}
+
+	nextFunc := func(it *iop.Iterator) bool {
+		if result.Next() {
+			row, err := result.SliceScan()
+			if err != nil {
+				result.Close()
+				c.Rollback()
+				it.Context.CaptureErr(g.Error(err, "could not scan row"))
+				return false
+			}
+			it.Row = row
+			return true
+		}
+
+		result.Close()
+		if err := result.Err(); err != nil {
+			c.Rollback()
+			it.Context.CaptureErr(g.Error(err, "error during iteration"))
+		} else if err := c.Commit(); err != nil {
+			it.Context.CaptureErr(g.Error(err, "could not commit transaction"))
+		}
+		return false
+	}
+
+	ds = iop.NewDatastreamIt(ctx, resultColumns(fields, tableColumns), nextFunc)
+	ds.Inferred = len(tableColumns) > 0
+	if err = ds.Start(); err != nil {
+		result.Close()
+		c.Rollback()
+		return ds, g.Error(err, "could not start datastream")
+	}
+
+	return
+}
+
+// checkUnfiltered refuses writes on the whole table,
+// unless `.all=true` is explicitly provided
+func checkUnfiltered(req *Request, filters Filters) error {
+	if len(filters) == 0 && !cast.ToBool(req.echoCtx.QueryParam(".all")) {
+		return g.Error("a filter is required. To apply on all rows, provide `.all=true`")
+	}
+	return nil
+}
+
+// execTableWrite executes the write statement in a transaction, after
+// the row filter checks, and returns the number of affected rows
+func execTableWrite(c database.Connection, req *Request, sql string, args []any, checks ...rowFilterCheck) (count int64, err error) {
+	ctx := req.echoCtx.Request().Context()
+	req.echoCtx.Set("sql", sql) // for middleware
+	err = c.BeginContext(ctx)
+	if err != nil {
+		err = g.Error(err, "could not begin transaction")
+		return
+	}
+
+	if err = checkRowFilters(c, req, checks); err != nil {
+		c.Rollback()
+		return
+	}
+
+	res, err := c.ExecContext(ctx, sql, args...)
+	if err != nil {
+		c.Rollback()
+		err = g.Error(err, "could not execute statement")
+		return
+	}
+
+	count, _ = res.RowsAffected()
+
+	err = c.Commit()
+	if err != nil {
+		err = g.Error(err, "could not commit transaction")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dbrest-io/dbrest/state"
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+// rowFilterCheck is a query counting the written rows which would not
+// match the row filters. It is run in the transaction of the write,
+// which is rolled back when the count is not zero.
+type rowFilterCheck struct {
+	sql  string
+	args []any
+}
+
+// updateRowFilterCheck returns the check of an update: the row filters are
+// evaluated on the rows matching the filters, with the values to set
+func updateRowFilterCheck(c database.Connection, req *Request, tableColumns iop.Columns, values map[string]any, filters Filters, rowFilters []string) (check rowFilterCheck, err error) {
+	fr := NewFilterRenderer(c, req.WritableColumns(state.OperationUpdate, req.dbTable, tableColumns))
+	fr.RowFilters, fr.Attributes = rowFilters, req.Attributes
+
+	setValues := map[string]string{}
+	keys := lo.Keys(values)
+	sort.Strings(keys)
+	for _, key := range keys {
+		col, err := fr.Column(key)
+		if err != nil {
+			return check, err
+		}
+		setValues[col.Name], err = fr.BodyValue(col, values[key])
+		if err != nil {
+			return check, err
+		}
+	}
+
+	// the set values replace the current ones
+	exprs := []string{}
+	for _, col := range tableColumns {
+		name := c.Quote(col.Name, false)
+		if value, ok := setValues[col.Name]; ok {
+			exprs = append(exprs, g.F("%s as %s", rowFilterValue(c, col, value), name))
+		} else {
+			exprs = append(exprs, name)
+		}
+	}
+
+	fr.Columns = req.ReadableColumns(req.dbTable, tableColumns)
+	where, err := fr.Where(filters)
+	if err != nil {
+		return check, g.Error(err, "invalid filter")
+	}
+
+	predicate, err := fr.Where(nil)
+	if err != nil {
+		return check, err
+	}
+
+	check.sql = g.R(
+		"select count(*) as cnt from (select {columns} from {table} where {where}) t where case when {predicate} then 0 else 1 end = 1",
+		"columns", strings.Join(exprs, ", "),
+		"table", req.dbTable.FullName(),
+		"where", where,
+		"predicate", predicate,
+	)
+	check.args = fr.Args
+	return check, nil
+}
+
+// insertRowFilterChecks returns the checks of the rows to insert: the row
+// filters are evaluated on the values, in batches of bind values. Columns
+// referenced by the row filters must be provided.
+func insertRowFilterChecks(c database.Connection, req *Request, columns iop.Columns, rows [][]any, rowFilters []string) (checks []rowFilterCheck, err error) {
+	batchSize := lo.Max([]int{1, maxBindValues / lo.Max([]int{1, len(columns)})})
+	from := lo.Ternary(c.GetType() == dbio.TypeDbOracle, " from dual", "")
+
+	for _, batch := range lo.Chunk(rows, batchSize) {
+		fr := NewFilterRenderer(c, columns)
+		fr.RowFilters, fr.Attributes = rowFilters, req.Attributes
+
+		selects := []string{}
+		for _, row := range batch {
+			exprs := []string{}
+			for i, col := range columns {
+				var val any
+				if i < len(row) {
+					val = row[i]
+				}
+				value, err := fr.BodyValue(col, val)
+				if err != nil {
+					return nil, err
+				}
+				exprs = append(exprs, g.F("%s as %s", rowFilterValue(c, col, value), c.Quote(col.Name, false)))
+			}
+			selects = append(selects, "select "+strings.Join(exprs, ", ")+from)
+		}
+
+		predicate, err := fr.Where(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		checks = append(checks, rowFilterCheck{
+			sql: g.F(
+				"select count(*) as cnt from (%s) t where case when %s then 0 else 1 end = 1",
+				strings.Join(selects, " union all "), predicate,
+			),
+			args: fr.Args,
+		})
+	}
+
+	return checks, nil
+}
+
+// rowFilterValue casts the value to the column type where bind values
+// are not typed by the database (postgres infers text in a select list)
+func rowFilterValue(c database.Connection, col iop.Column, value string) string {
+	switch c.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbRedshift:
+		if col.DbType != "" {
+			return g.F("cast(%s as %s)", value, col.DbType)
+		}
+	}
+	return value
+}
+
+// checkRowFilters runs the checks in the current transaction, and errors
+// if a written row would not match the row filters
+func checkRowFilters(c database.Connection, req *Request, checks []rowFilterCheck) error {
+	for _, check := range checks {
+		data, err := queryRows(c, req, check.sql, check.args, nil)
+		if err != nil {
+			return g.Error(err, "could not check the row filter")
+		} else if len(data.Rows) > 0 && len(data.Rows[0]) > 0 && cast.ToInt64(data.Rows[0][0]) > 0 {
+			return g.Error("the written rows must match the row filter")
+		}
+	}
+	return nil
+}
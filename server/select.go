@@ -0,0 +1,404 @@
+package server
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+// OrderBy is an ordering term parsed from `.order`
+// e.g. `.order=country.desc.nullslast,city`
+type OrderBy struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+	Nulls  string `json:"nulls,omitempty"` // `first` or `last`
+}
+
+// ParseOrder parses the value of `.order`
+func ParseOrder(value string) (orders []OrderBy, err error) {
+	for _, term := range strings.Split(value, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		parts := strings.Split(term, ".")
+		order := OrderBy{Column: parts[0]}
+		for _, modifier := range parts[1:] {
+			switch strings.ToLower(modifier) {
+			case "asc":
+				order.Desc = false
+			case "desc":
+				order.Desc = true
+			case "nullsfirst":
+				order.Nulls = "first"
+			case "nullslast":
+				order.Nulls = "last"
+			default:
+				return nil, g.Error("invalid order modifier `%s` for %s", modifier, parts[0])
+			}
+		}
+		orders = append(orders, order)
+	}
+	return
+}
+
+// Cursor is the keyset position of the last row of a page.
+// It is sent to the client as an opaque token.
+type Cursor struct {
+	Columns []string `json:"c"`
+	Values  []string `json:"v"`
+	Nulls   []bool   `json:"n,omitempty"` // true where the value is NULL
+}
+
+// IsNull returns true if the value at the index is NULL
+func (c Cursor) IsNull(i int) bool {
+	return i < len(c.Nulls) && c.Nulls[i]
+}
+
+// EncodeCursor returns the opaque token for the cursor
+func EncodeCursor(cursor Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(g.Marshal(cursor)))
+}
+
+// DecodeCursor parses the opaque token. An empty token
+// is the start of the first page.
+func DecodeCursor(token string) (cursor Cursor, err error) {
+	if token == "" {
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, g.Error("invalid cursor")
+	}
+
+	err = g.JSONUnmarshal(b, &cursor)
+	if err != nil || len(cursor.Columns) != len(cursor.Values) || (len(cursor.Nulls) > 0 && len(cursor.Nulls) != len(cursor.Values)) {
+		return cursor, g.Error("invalid cursor")
+	}
+
+	return
+}
+
+// TableSelect holds the parts of a select query on a table
+type TableSelect struct {
+	Table   database.Table
+	Fields  iop.Columns // selected fields, all when empty
+	Filters Filters
+	Order   []OrderBy
+	Limit   int // 0 or -1 is unlimited
+	Offset  int
+	Cursor  *Cursor // when not nil, keyset paging is used
+}
+
+// SQL renders the select query. The bind values are added to fr.Args
+func (ts *TableSelect) SQL(fr *FilterRenderer) (sql string, err error) {
+	connType := fr.Conn.GetType()
+
+	fields := "*"
+	if len(ts.Fields) > 0 {
+		fields = strings.Join(lo.Map(ts.Fields, func(c iop.Column, i int) string {
+			return fr.Conn.Quote(c.Name, false)
+		}), ", ")
+	}
+
+	where, err := fr.Where(ts.Filters)
+	if err != nil {
+		return "", err
+	}
+
+	if ts.Cursor != nil && len(ts.Cursor.Values) > 0 {
+		keyset, err := fr.Keyset(ts.Order, *ts.Cursor)
+		if err != nil {
+			return "", err
+		}
+		where = g.F("(%s) and (%s)", where, keyset)
+	}
+
+	orderBy, err := fr.OrderBy(ts.Order)
+	if err != nil {
+		return "", err
+	}
+
+	var preOptions, postOptions []string
+	limit := lo.Ternary(ts.Limit > 0, ts.Limit, 0)
+
+	switch connType {
+	case dbio.TypeDbSQLServer, dbio.TypeDbAzure, dbio.TypeDbAzureDWH:
+		if ts.Offset > 0 {
+			// offset requires an order by clause
+			orderBy = lo.Ternary(orderBy == "", "(select null)", orderBy)
+			postOptions = append(postOptions, g.F("offset %d rows", ts.Offset))
+			if limit > 0 {
+				postOptions = append(postOptions, g.F("fetch next %d rows only", limit))
+			}
+		} else if limit > 0 {
+			preOptions = append(preOptions, g.F("top %d", limit))
+		}
+	case dbio.TypeDbOracle:
+		if ts.Offset > 0 {
+			postOptions = append(postOptions, g.F("offset %d rows", ts.Offset))
+		}
+		if limit > 0 {
+			postOptions = append(postOptions, g.F("fetch next %d rows only", limit))
+		}
+	default:
+		if limit > 0 {
+			postOptions = append(postOptions, g.F("limit %d", limit))
+		} else if ts.Offset > 0 {
+			// some databases require a limit with an offset
+			switch connType {
+			case dbio.TypeDbMySQL, dbio.TypeDbMariaDB, dbio.TypeDbStarRocks:
+				postOptions = append(postOptions, "limit 18446744073709551615")
+			case dbio.TypeDbSQLite:
+				postOptions = append(postOptions, "limit -1")
+			}
+		}
+		if ts.Offset > 0 {
+			postOptions = append(postOptions, g.F("offset %d", ts.Offset))
+		}
+	}
+
+	if orderBy != "" {
+		postOptions = append([]string{"order by " + orderBy}, postOptions...)
+	}
+
+	sql = g.R(
+		"select{preOptions} {fields} from {table} where {where}{postOptions}",
+		"fields", fields,
+		"table", ts.Table.FullName(),
+		"where", where,
+		"preOptions", lo.Ternary(len(preOptions) > 0, " "+strings.Join(preOptions, " "), ""),
+		"postOptions", lo.Ternary(len(postOptions) > 0, " "+strings.Join(postOptions, " "), ""),
+	)
+
+	return sql, nil
+}
+
+// prepareKeyset makes the order deterministic for keyset paging
+// by appending the primary key columns, and makes sure that the
+// order columns are selected so the next cursor can be made
+func (ts *TableSelect) prepareKeyset(conn database.Connection, fr *FilterRenderer) (err error) {
+	ts.Limit = lo.Ternary(ts.Limit > 0, ts.Limit, 100) // pages must be bounded
+
+	pkColumns, err := getPrimaryKeys(conn, ts.Table)
+	if err != nil {
+		return g.Error(err, "could not get primary keys")
+	}
+
+	isPK := func(column string) bool {
+		return lo.ContainsBy(pkColumns, func(pkColumn string) bool {
+			return strings.EqualFold(column, pkColumn)
+		})
+	}
+
+	// the other columns may be null, so their nulls position must
+	// be known to page past them
+	for i, order := range ts.Order {
+		if order.Nulls == "" && !isPK(order.Column) {
+			ts.Order[i].Nulls = "last"
+		}
+	}
+
+	for _, pkColumn := range pkColumns {
+		found := lo.ContainsBy(ts.Order, func(o OrderBy) bool {
+			return strings.EqualFold(o.Column, pkColumn)
+		})
+		if !found {
+			ts.Order = append(ts.Order, OrderBy{Column: pkColumn})
+		}
+	}
+
+	if len(ts.Order) == 0 {
+		return g.Error("keyset paging requires `.order` or a primary key")
+	}
+
+	if len(ts.Fields) > 0 {
+		for _, order := range ts.Order {
+			col, err := fr.Column(order.Column)
+			if err != nil {
+				return err
+			}
+			if ts.Fields.GetColumn(col.Name) == nil {
+				ts.Fields = append(ts.Fields, col)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NextCursor returns the cursor positioned at the last row of the page
+func (ts *TableSelect) NextCursor(data iop.Dataset) (cursor Cursor, ok bool) {
+	if len(data.Rows) == 0 || len(data.Rows) < ts.Limit {
+		return cursor, false // last page
+	}
+
+	lastRow := data.Rows[len(data.Rows)-1]
+	for _, order := range ts.Order {
+		index := -1
+		for i, col := range data.Columns {
+			if strings.EqualFold(col.Name, order.Column) {
+				index = i
+			}
+		}
+		if index == -1 {
+			return cursor, false
+		}
+
+		var value string
+		isNull := lastRow[index] == nil
+		switch v := lastRow[index].(type) {
+		case time.Time:
+			value = v.Format(time.RFC3339Nano)
+		case *time.Time:
+			value = v.Format(time.RFC3339Nano)
+		default:
+			value = cast.ToString(v)
+		}
+
+		cursor.Columns = append(cursor.Columns, order.Column)
+		cursor.Values = append(cursor.Values, value)
+		cursor.Nulls = append(cursor.Nulls, isNull)
+	}
+
+	if !lo.Contains(cursor.Nulls, true) {
+		cursor.Nulls = nil // shorter token
+	}
+
+	return cursor, true
+}
+
+// OrderBy renders the order by expression, validating the columns.
+// Nulls ordering is emulated for databases without NULLS FIRST/LAST
+func (fr *FilterRenderer) OrderBy(orders []OrderBy) (expr string, err error) {
+	terms := []string{}
+	for _, order := range orders {
+		field, err := fr.Quote(order.Column)
+		if err != nil {
+			return "", err
+		}
+
+		direction := lo.Ternary(order.Desc, "desc", "asc")
+		switch {
+		case order.Nulls == "":
+			terms = append(terms, g.F("%s %s", field, direction))
+		case fr.supportsNullsOrder():
+			terms = append(terms, g.F("%s %s nulls %s", field, direction, order.Nulls))
+		default:
+			nullsFirst := order.Nulls == "first"
+			terms = append(terms, g.F(
+				"case when %s is null then %d else %d end",
+				field, lo.Ternary(nullsFirst, 0, 1), lo.Ternary(nullsFirst, 1, 0),
+			))
+			terms = append(terms, g.F("%s %s", field, direction))
+		}
+	}
+
+	return strings.Join(terms, ", "), nil
+}
+
+// Keyset renders the condition to fetch the rows after the cursor.
+// For `a asc, b desc` it is: (a > ?) or (a = ? and b < ?).
+// NULLs are placed according to the nulls position of the order column,
+// and columns without one (the primary key) are expected not null.
+func (fr *FilterRenderer) Keyset(orders []OrderBy, cursor Cursor) (expr string, err error) {
+	if len(orders) != len(cursor.Columns) {
+		return "", g.Error("cursor does not match order")
+	}
+
+	ors := []string{}
+	for i, order := range orders {
+		if !strings.EqualFold(order.Column, cursor.Columns[i]) {
+			return "", g.Error("cursor does not match order")
+		}
+
+		ands := []string{}
+		for j := 0; j <= i; j++ {
+			col, err := fr.Column(orders[j].Column)
+			if err != nil {
+				return "", err
+			}
+			field := fr.Conn.Quote(col.Name, false)
+
+			isNull := cursor.IsNull(j)
+			if isNull && orders[j].Nulls == "" {
+				return "", g.Error("invalid cursor, %s cannot be null", col.Name)
+			}
+
+			if j < i {
+				if isNull {
+					ands = append(ands, g.F("%s is null", field))
+					continue
+				}
+				value, err := fr.Value(col, cursor.Values[j])
+				if err != nil {
+					return "", err
+				}
+				ands = append(ands, g.F("%s = %s", field, value))
+				continue
+			}
+
+			// the rows after the cursor value, in the order
+			switch {
+			case isNull && orders[j].Nulls == "last":
+				ands = nil // nothing comes after the last nulls
+			case isNull:
+				ands = append(ands, g.F("%s is not null", field))
+			default:
+				value, err := fr.Value(col, cursor.Values[j])
+				if err != nil {
+					return "", err
+				}
+				operator := lo.Ternary(orders[j].Desc, "<", ">")
+				if orders[j].Nulls == "last" {
+					ands = append(ands, g.F("(%s %s %s or %s is null)", field, operator, value, field))
+				} else {
+					ands = append(ands, g.F("%s %s %s", field, operator, value))
+				}
+			}
+		}
+
+		if len(ands) > 0 {
+			ors = append(ors, "("+strings.Join(ands, " and ")+")")
+		}
+	}
+
+	if len(ors) == 0 {
+		return "1 = 0", nil // last row
+	}
+
+	return strings.Join(ors, " or "), nil
+}
+
+func (fr *FilterRenderer) supportsNullsOrder() bool {
+	switch fr.Conn.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbRedshift, dbio.TypeDbOracle, dbio.TypeDbSnowflake,
+		dbio.TypeDbSQLite, dbio.TypeDbDuckDb, dbio.TypeDbMotherDuck, dbio.TypeDbBigQuery:
+		return true
+	}
+	return false
+}
+
+// getPrimaryKeys returns the primary key column names of a table
+func getPrimaryKeys(conn database.Connection, table database.Table) (columns []string, err error) {
+	data, err := conn.GetPrimaryKeys(table.FullName())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range data.Records(true) {
+		if name := cast.ToString(rec["column_name"]); name != "" {
+			columns = append(columns, name)
+		}
+	}
+	return
+}
@@ -16,17 +16,22 @@ type Server struct {
 	Port       string
 	EchoServer *echo.Echo
 	StartTime  time.Time
+	Audit      *state.AuditLog // nil when disabled
 }
 
 func NewServer() (s *Server) {
-	s = &Server{EchoServer: echo.New(), Port: "1323"}
+	s = &Server{EchoServer: echo.New(), Port: "1323", Audit: state.LoadAuditLog()}
 	if port := os.Getenv("PORT"); port != "" {
 		s.Port = port
 	}
 
 	// add routes
-	for _, route := range StandardRoutes {
+	for _, route := range append(StandardRoutes, s.HealthRoutes()...) {
 		route.Middlewares = append(route.Middlewares, middleware.Logger())
+		route.Middlewares = append(route.Middlewares, metricsMiddleware(route.Name))
+		if s.Audit != nil {
+			route.Middlewares = append(route.Middlewares, auditMiddleware(s.Audit, route.Name))
+		}
 		route.Middlewares = append(route.Middlewares, middleware.Recover())
 		s.EchoServer.AddRoute(route)
 	}
@@ -36,7 +41,7 @@ func NewServer() (s *Server) {
 		// AllowOrigins: []string{"http://localhost:1323"},
 		// AllowCredentials: true,
 		// AllowHeaders: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, "X-Request-ID", "X-Request-Columns", "X-Request-Continue", "X-Project-ID", "access-control-allow-origin", "access-control-allow-headers"},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, "X-Request-ID", "X-Request-Columns", "X-Request-Continue", "X-Project-ID", "Prefer", "access-control-allow-origin", "access-control-allow-headers"},
 		AllowOriginFunc: func(origin string) (bool, error) {
 			return true, nil
 		},
@@ -57,5 +62,6 @@ func (s *Server) Hostname() string {
 }
 
 func (s *Server) Close() {
+	s.Audit.Close()
 	state.CloseConnections()
 }
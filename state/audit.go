@@ -0,0 +1,319 @@
+package state
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbrest-io/dbrest/env"
+	"github.com/flarco/g"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/slingdata-io/sling-cli/core/dbio/iop"
+	"github.com/spf13/cast"
+)
+
+// AuditEvent is the record of one API call
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Token      string    `json:"token,omitempty"` // token name, or JWT subject
+	Roles      []string  `json:"roles,omitempty"`
+	Project    string    `json:"project,omitempty"`
+	Connection string    `json:"connection,omitempty"`
+	Route      string    `json:"route"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Table      string    `json:"table,omitempty"`
+	SQL        string    `json:"sql,omitempty"` // generated or submitted
+	QueryID    string    `json:"query_id,omitempty"`
+	Rows       int64     `json:"rows"`     // rows returned
+	Affected   int64     `json:"affected"` // rows written
+	Status     int       `json:"status"`
+	Duration   int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	RemoteIP   string    `json:"remote_ip,omitempty"`
+}
+
+// auditColumns are the columns of the audit table
+var auditColumns = iop.Columns{
+	{Name: "time", Type: iop.TimestampType},
+	{Name: "request_id", Type: iop.StringType},
+	{Name: "token", Type: iop.StringType},
+	{Name: "roles", Type: iop.StringType},
+	{Name: "project", Type: iop.StringType},
+	{Name: "connection", Type: iop.StringType},
+	{Name: "route", Type: iop.StringType},
+	{Name: "method", Type: iop.StringType},
+	{Name: "path", Type: iop.TextType},
+	{Name: "table_name", Type: iop.StringType},
+	{Name: "sql_text", Type: iop.TextType},
+	{Name: "query_id", Type: iop.StringType},
+	{Name: "rows", Type: iop.BigIntType},
+	{Name: "affected", Type: iop.BigIntType},
+	{Name: "status", Type: iop.IntegerType},
+	{Name: "duration_ms", Type: iop.BigIntType},
+	{Name: "error", Type: iop.TextType},
+	{Name: "remote_ip", Type: iop.StringType},
+}
+
+func (ae AuditEvent) row() []any {
+	return []any{
+		ae.Time, ae.RequestID, ae.Token, strings.Join(ae.Roles, ","),
+		ae.Project, ae.Connection, ae.Route, ae.Method, ae.Path, ae.Table,
+		ae.SQL, ae.QueryID, ae.Rows, ae.Affected, ae.Status, ae.Duration,
+		ae.Error, ae.RemoteIP,
+	}
+}
+
+// AuditLog writes the audit events to a JSONL file, rotated when it
+// reaches MaxSize, and optionally to a table of a connection of the
+// default project.
+type AuditLog struct {
+	File       string
+	MaxSize    int64 // bytes
+	MaxFiles   int   // rotated files to keep
+	Connection string
+	Table      string
+
+	file      *os.File
+	size      int64
+	events    chan AuditEvent    // for the table
+	done      chan chan struct{} // stops the table loop, closed once the queue is written
+	closeOnce sync.Once
+	mux       sync.Mutex
+}
+
+// LoadAuditLog loads the audit log configuration from the environment
+// variables DBREST_AUDIT (set to true to enable), DBREST_AUDIT_FILE,
+// DBREST_AUDIT_MAX_SIZE (MB), DBREST_AUDIT_MAX_FILES,
+// DBREST_AUDIT_CONNECTION and DBREST_AUDIT_TABLE.
+// Returns nil if disabled, the default.
+func LoadAuditLog() *AuditLog {
+	if !cast.ToBool(os.Getenv("DBREST_AUDIT")) {
+		return nil
+	}
+
+	al := &AuditLog{
+		File:       os.Getenv("DBREST_AUDIT_FILE"),
+		MaxSize:    cast.ToInt64(os.Getenv("DBREST_AUDIT_MAX_SIZE")) * 1024 * 1024,
+		MaxFiles:   cast.ToInt(os.Getenv("DBREST_AUDIT_MAX_FILES")),
+		Connection: strings.ToLower(os.Getenv("DBREST_AUDIT_CONNECTION")),
+		Table:      os.Getenv("DBREST_AUDIT_TABLE"),
+	}
+	if al.File == "" {
+		al.File = path.Join(env.HomeDir, "audit", "audit.jsonl")
+	}
+	if al.MaxSize <= 0 {
+		al.MaxSize = 100 * 1024 * 1024
+	}
+	if al.MaxFiles <= 0 {
+		al.MaxFiles = 10
+	}
+
+	if al.Connection != "" {
+		if al.Table == "" {
+			al.Table = "dbrest_audit_log"
+		}
+		al.events = make(chan AuditEvent, 10000)
+		al.done = make(chan chan struct{})
+		go al.loopTable()
+	}
+
+	return al
+}
+
+// Log records the event. The file is written synchronously,
+// the table asynchronously (in batches).
+func (al *AuditLog) Log(event AuditEvent) {
+	if al == nil {
+		return
+	}
+
+	if err := al.writeFile(event); err != nil {
+		g.LogError(g.Error(err, "could not write audit event"))
+	}
+
+	if al.events != nil {
+		select {
+		case al.events <- event:
+		default:
+			g.Warn("audit table queue is full, event %s only written to file", event.RequestID)
+		}
+	}
+}
+
+// Close writes the queued events to the table, stopping its loop,
+// and closes the audit file
+func (al *AuditLog) Close() {
+	if al == nil {
+		return
+	}
+
+	if al.done != nil {
+		al.closeOnce.Do(func() {
+			done := make(chan struct{})
+			al.done <- done
+			<-done
+		})
+	}
+
+	al.mux.Lock()
+	defer al.mux.Unlock()
+	if al.file != nil {
+		al.file.Close()
+		al.file = nil
+	}
+}
+
+func (al *AuditLog) writeFile(event AuditEvent) (err error) {
+	line := append([]byte(g.Marshal(event)), '\n')
+
+	al.mux.Lock()
+	defer al.mux.Unlock()
+
+	if al.file != nil && al.size+int64(len(line)) > al.MaxSize {
+		al.file.Close()
+		al.file = nil
+		if err = al.rotate(); err != nil {
+			return g.Error(err, "could not rotate audit file")
+		}
+	}
+
+	if al.file == nil {
+		if err = os.MkdirAll(path.Dir(al.File), 0755); err != nil {
+			return g.Error(err, "could not create audit folder")
+		}
+
+		al.file, err = os.OpenFile(al.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return g.Error(err, "could not open audit file")
+		}
+
+		stat, err := al.file.Stat()
+		if err != nil {
+			return g.Error(err, "could not stat audit file")
+		}
+		al.size = stat.Size()
+	}
+
+	n, err := al.file.Write(line)
+	al.size += int64(n)
+	return err
+}
+
+// rotate renames audit.jsonl to audit.jsonl.1, audit.jsonl.1 to
+// audit.jsonl.2, etc., removing the oldest beyond MaxFiles
+func (al *AuditLog) rotate() (err error) {
+	os.Remove(g.F("%s.%d", al.File, al.MaxFiles))
+	for i := al.MaxFiles - 1; i >= 1; i-- {
+		oldPath := g.F("%s.%d", al.File, i)
+		if g.PathExists(oldPath) {
+			if err = os.Rename(oldPath, g.F("%s.%d", al.File, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(al.File, al.File+".1")
+}
+
+// loopTable inserts the queued events into the table, in batches,
+// until the audit log is closed
+func (al *AuditLog) loopTable() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	batch := []AuditEvent{}
+	for {
+		var done chan struct{}
+		select {
+		case event := <-al.events:
+			batch = append(batch, event)
+			if len(batch) < 500 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done = <-al.done:
+			for drained := false; !drained; {
+				select {
+				case event := <-al.events:
+					batch = append(batch, event)
+				default:
+					drained = true
+				}
+			}
+		}
+
+		if len(batch) > 0 {
+			if err := al.insertTable(batch); err != nil {
+				g.LogError(g.Error(err, "could not insert %d audit events into table %s", len(batch), al.Table))
+			}
+			batch = []AuditEvent{}
+		}
+		if done != nil {
+			close(done)
+			return
+		}
+	}
+}
+
+func (al *AuditLog) insertTable(events []AuditEvent) (err error) {
+	project := DefaultProject()
+	if project == nil {
+		return g.Error("no default project")
+	}
+
+	conn, err := project.GetConnInstance(al.Connection, "")
+	if err != nil {
+		return g.Error(err, "could not get audit connection")
+	}
+
+	table, err := database.ParseTableName(al.Table, conn.GetType())
+	if err != nil {
+		return g.Error(err, "could not parse audit table name")
+	}
+
+	if _, err = conn.GetTableColumns(&table); err != nil {
+		if err = conn.CreateTable(table.FullName(), auditColumns, ""); err != nil {
+			return g.Error(err, "could not create audit table")
+		}
+	}
+
+	data := iop.NewDataset(auditColumns)
+	for _, event := range events {
+		data.Append(event.row())
+	}
+
+	_, err = conn.InsertBatchStream(table.FullName(), data.Stream())
+	if err != nil {
+		return g.Error(err, "could not insert audit events")
+	}
+
+	return nil
+}
+
+// ReadAuditFile reads the events of the current audit file
+func ReadAuditFile(filePath string) (events []AuditEvent, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, g.Error(err, "could not open audit file")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		event := AuditEvent{}
+		if err = g.JSONUnmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, g.Error(err, "could not parse audit event")
+		}
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
@@ -1,7 +1,12 @@
 package state
 
 import (
+	"path"
+	"sort"
+	"strings"
+
 	"github.com/flarco/g"
+	"github.com/samber/lo"
 	"github.com/slingdata-io/sling-cli/core/dbio/connection"
 	"github.com/slingdata-io/sling-cli/core/dbio/database"
 )
@@ -10,59 +15,382 @@ type Grant struct {
 	// AllowRead lists the schema/tables that are allowed to be read from
 	AllowRead []string `json:"allow_read" yaml:"allow_read"`
 	// AllowWrite lists the schema/tables that are allowed to be written to
+	// (insert, update, upsert & delete)
 	AllowWrite []string `json:"allow_write" yaml:"allow_write"`
+	// AllowInsert lists the schema/tables that are allowed to be inserted into
+	AllowInsert []string `json:"allow_insert,omitempty" yaml:"allow_insert,omitempty"`
+	// AllowUpdate lists the schema/tables that are allowed to be updated
+	AllowUpdate []string `json:"allow_update,omitempty" yaml:"allow_update,omitempty"`
+	// AllowUpsert lists the schema/tables that are allowed to be upserted into
+	AllowUpsert []string `json:"allow_upsert,omitempty" yaml:"allow_upsert,omitempty"`
+	// AllowDelete lists the schema/tables that are allowed to be deleted from
+	AllowDelete []string `json:"allow_delete,omitempty" yaml:"allow_delete,omitempty"`
+	// AllowExecute lists the stored procedures and functions (`schema.name`)
+	// that are allowed to be executed
+	AllowExecute []string `json:"allow_execute,omitempty" yaml:"allow_execute,omitempty"`
 	// AllowSQL shows whether a
 	AllowSQL AllowSQLValue `json:"allow_sql" yaml:"allow_sql"`
+	// RowFilter is a map of object (table, `schema.*` or `*`) to a SQL predicate
+	// which is and-ed to table selects, updates and deletes. Token attributes
+	// can be referenced with `{token.<attribute>}`, e.g. `region = '{token.region}'`
+	RowFilter map[string]string `json:"row_filter,omitempty" yaml:"row_filter,omitempty"`
 }
 
 // Permissions is a map of all objects for one connection
 type Permissions map[string]Permission
 
-type Permission string
+// Permission is the set of operations allowed on an object
+type Permission uint8
+
+const (
+	PermissionNone    Permission = 0
+	PermissionSelect  Permission = 1 << 0
+	PermissionInsert  Permission = 1 << 1
+	PermissionUpdate  Permission = 1 << 2
+	PermissionUpsert  Permission = 1 << 3
+	PermissionDelete  Permission = 1 << 4
+	PermissionExecute Permission = 1 << 5
+
+	PermissionRead      = PermissionSelect
+	PermissionWrite     = PermissionInsert | PermissionUpdate | PermissionUpsert | PermissionDelete
+	PermissionReadWrite = PermissionRead | PermissionWrite
+)
+
+// Operation is an operation on a table, or the execution of a routine
+type Operation string
 
 const (
-	PermissionNone      Permission = "none"
-	PermissionRead      Permission = "read"
-	PermissionWrite     Permission = "write"
-	PermissionReadWrite Permission = "read_write"
+	OperationSelect  Operation = "select"
+	OperationInsert  Operation = "insert"
+	OperationUpdate  Operation = "update"
+	OperationUpsert  Operation = "upsert"
+	OperationDelete  Operation = "delete"
+	OperationExecute Operation = "execute"
 )
 
+// Operations are all the table operations
+var Operations = []Operation{OperationSelect, OperationInsert, OperationUpdate, OperationUpsert, OperationDelete}
+
+// Permission returns the permission of the operation
+func (op Operation) Permission() Permission {
+	switch op {
+	case OperationSelect:
+		return PermissionSelect
+	case OperationInsert:
+		return PermissionInsert
+	case OperationUpdate:
+		return PermissionUpdate
+	case OperationUpsert:
+		return PermissionUpsert
+	case OperationDelete:
+		return PermissionDelete
+	case OperationExecute:
+		return PermissionExecute
+	}
+	return PermissionNone
+}
+
+// Can returns true if the operation is allowed
+func (p Permission) Can(op Operation) bool {
+	return op.Permission() != PermissionNone && p&op.Permission() != 0
+}
+
 func (p Permission) CanRead() bool {
-	return p == PermissionRead || p == PermissionReadWrite
+	return p&PermissionRead != 0
 }
 
+// CanWrite returns true if any write operation is allowed
 func (p Permission) CanWrite() bool {
-	return p == PermissionWrite || p == PermissionReadWrite
+	return p&PermissionWrite != 0
+}
+
+// Operations returns the allowed operations
+func (p Permission) Operations() (ops []Operation) {
+	return lo.Filter(Operations, func(op Operation, i int) bool { return p.Can(op) })
+}
+
+func (p Permission) String() string {
+	if p == PermissionNone {
+		return "none"
+	}
+	ops := p.Operations()
+	if p.Can(OperationExecute) {
+		ops = append(ops, OperationExecute)
+	}
+	return strings.Join(lo.Map(ops, func(op Operation, i int) string { return string(op) }), ",")
+}
+
+// Entries returns the grant entries of each permission
+func (gt Grant) Entries() map[Permission][]string {
+	return map[Permission][]string{
+		PermissionRead:    gt.AllowRead,
+		PermissionWrite:   gt.AllowWrite,
+		PermissionInsert:  gt.AllowInsert,
+		PermissionUpdate:  gt.AllowUpdate,
+		PermissionUpsert:  gt.AllowUpsert,
+		PermissionDelete:  gt.AllowDelete,
+		PermissionExecute: gt.AllowExecute,
+	}
 }
 
 type AllowSQLValue string
 
 const (
-	AllowSQLDisable AllowSQLValue = "disable"
-	AllowSQLAny     AllowSQLValue = "any"
-	// AllowSQLOnlySelect AllowSQLValue = "only_select"
+	AllowSQLDisable    AllowSQLValue = "disable"
+	AllowSQLAny        AllowSQLValue = "any"
+	AllowSQLOnlySelect AllowSQLValue = "only_select"
 )
 
 func (gt Grant) GetReadable(conn connection.Connection) (tables []database.Table) {
-	for _, t := range gt.AllowRead {
-		table, err := database.ParseTableName(t, conn.Type)
+	tables, _, denies := parseGrantEntries(gt.AllowRead, conn)
+	return lo.Filter(tables, func(t database.Table, i int) bool { return !denies[i] })
+}
+
+func (gt Grant) GetWritable(conn connection.Connection) (tables []database.Table) {
+	tables, _, denies := parseGrantEntries(gt.AllowWrite, conn)
+	return lo.Filter(tables, func(t database.Table, i int) bool { return !denies[i] })
+}
+
+// Get returns the permission on the table: the union of the permissions
+// of the matching entries (exact names or glob patterns such as
+// `sales.fact_*` or `*.audit_log`), minus the union of the matching
+// deny entries (keys prefixed with `!`). Deny entries always take
+// precedence, whatever the order and the roles they come from.
+func (ps Permissions) Get(table database.Table) (p Permission) {
+	denied := PermissionNone
+	for object, permission := range ps {
+		if deny := strings.HasPrefix(object, "!"); deny && ObjectMatches(object[1:], table) {
+			denied |= permission
+		} else if !deny && ObjectMatches(object, table) {
+			p |= permission
+		}
+	}
+	return p &^ denied
+}
+
+// Objects returns the (non-deny) objects matching the table,
+// ordered from least to most specific
+func (ps Permissions) Objects(table database.Table) (objects []string) {
+	for object := range ps {
+		if !strings.HasPrefix(object, "!") && ObjectMatches(object, table) {
+			objects = append(objects, object)
+		}
+	}
+	SortObjects(objects)
+	return
+}
+
+// InSchema returns true if some tables of the schema may be accessed
+func (ps Permissions) InSchema(schema database.Table) bool {
+	allowed, denied := PermissionNone, PermissionNone
+	for object, permission := range ps {
+		deny := strings.HasPrefix(object, "!")
+		parts := objectParts(strings.TrimPrefix(object, "!"))
+		switch {
+		case len(parts) == 1 && parts[0] == "*":
+			if deny {
+				denied |= permission
+			} else {
+				allowed |= permission
+			}
+		case len(parts) < 2 || !ObjectMatches(strings.Join(parts[:len(parts)-1], ".")+".*", schema):
+			continue
+		case !deny:
+			allowed |= permission
+		case parts[len(parts)-1] == "*":
+			denied |= permission // whole schema is denied
+		}
+	}
+	return allowed&^denied != PermissionNone
+}
+
+// ObjectMatches returns true if the object (the full name of a table,
+// or a pattern with `*` and `?` wildcards) matches the table
+func ObjectMatches(object string, table database.Table) bool {
+	patterns := objectParts(object)
+	if len(patterns) == 1 && patterns[0] == "*" {
+		return true
+	}
+
+	names := objectParts(table.FullName())
+	if len(patterns) != len(names) {
+		return false
+	}
+
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, names[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SortObjects sorts the objects from least to most specific:
+// by decreasing number of wildcards, then by increasing length
+func SortObjects(objects []string) {
+	wildcards := func(object string) int { return strings.Count(object, "*") + strings.Count(object, "?") }
+	sort.Slice(objects, func(i, j int) bool {
+		if wi, wj := wildcards(objects[i]), wildcards(objects[j]); wi != wj {
+			return wi > wj
+		} else if len(objects[i]) != len(objects[j]) {
+			return len(objects[i]) < len(objects[j])
+		}
+		return objects[i] < objects[j]
+	})
+}
+
+// objectParts splits a full name into its unquoted parts,
+// e.g. `"sales"."fact_orders"` into `sales` & `fact_orders`
+func objectParts(fullName string) (parts []string) {
+	var quote rune
+	part := strings.Builder{}
+	for _, r := range fullName {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '`' || r == '['):
+			quote = lo.Ternary(r == '[', ']', r)
+		case quote == 0 && r == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// ColumnRule restricts the columns of a grant entry.
+// `hr.employees(-salary,-ssn)` excludes columns, while
+// `hr.employees(id,name)` only includes the listed columns.
+// An empty rule allows all columns.
+type ColumnRule struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Allows returns true if the column is allowed by the rule
+func (cr ColumnRule) Allows(column string) bool {
+	if len(cr.Include) > 0 {
+		return lo.ContainsBy(cr.Include, func(c string) bool { return strings.EqualFold(c, column) })
+	}
+	return !lo.ContainsBy(cr.Exclude, func(c string) bool { return strings.EqualFold(c, column) })
+}
+
+// ColumnRules is a map of the column rules per object (table, pattern,
+// `schema.*` or `*`) for one connection
+type ColumnRules map[string][]ColumnRule
+
+// Allows returns true if any of the rules of the objects allows the column.
+// Objects without rules are ignored, and if none of the objects
+// have rules, the column is allowed.
+func (cr ColumnRules) Allows(objects []string, column string) bool {
+	found := false
+	for _, object := range objects {
+		for _, rule := range cr[object] {
+			found = true
+			if rule.Allows(column) {
+				return true
+			}
+		}
+	}
+	return !found
+}
+
+// Restricted returns true if the rules of the objects do not
+// allow all the columns
+func (cr ColumnRules) Restricted(objects []string) bool {
+	found := false
+	for _, object := range objects {
+		for _, rule := range cr[object] {
+			found = true
+			if len(rule.Include) == 0 && len(rule.Exclude) == 0 {
+				return false
+			}
+		}
+	}
+	return found
+}
+
+// ColumnPermissions holds the column rules per operation for one connection
+type ColumnPermissions map[Operation]ColumnRules
+
+// ParseGrantEntry splits a grant entry such as `hr.employees(-salary,-ssn)`
+// into the object name and the column rule
+func ParseGrantEntry(entry string) (object string, rule ColumnRule, err error) {
+	entry = strings.TrimSpace(entry)
+	open := strings.Index(entry, "(")
+	if open == -1 || !strings.HasSuffix(entry, ")") {
+		return entry, rule, nil
+	}
+
+	object = strings.TrimSpace(entry[:open])
+	for _, column := range strings.Split(entry[open+1:len(entry)-1], ",") {
+		column = strings.TrimSpace(column)
+		switch {
+		case column == "":
+			continue
+		case strings.HasPrefix(column, "-"):
+			rule.Exclude = append(rule.Exclude, strings.TrimSpace(column[1:]))
+		default:
+			rule.Include = append(rule.Include, column)
+		}
+	}
+
+	if len(rule.Include) > 0 && len(rule.Exclude) > 0 {
+		return object, rule, g.Error("cannot both include and exclude columns in grant entry: %s", entry)
+	}
+
+	return object, rule, nil
+}
+
+// parseGrantEntries parses the grant entries into tables with their column
+// rules. Entries prefixed with `!` are deny entries.
+func parseGrantEntries(entries []string, conn connection.Connection) (tables []database.Table, rules []ColumnRule, denies []bool) {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		deny := strings.HasPrefix(entry, "!")
+
+		object, rule, err := ParseGrantEntry(strings.TrimPrefix(entry, "!"))
+		if err != nil {
+			g.Warn(err.Error())
+			continue
+		} else if deny && (len(rule.Include) > 0 || len(rule.Exclude) > 0) {
+			g.Warn("ignoring column rule of deny entry: %s", entry)
+			rule = ColumnRule{}
+		}
+
+		table, err := database.ParseTableName(object, conn.Type)
 		if err != nil {
-			g.Warn("could not parse table entry: %s", t)
+			g.Warn("could not parse table entry: %s", entry)
 			continue
 		}
 		tables = append(tables, table)
+		rules = append(rules, rule)
+		denies = append(denies, deny)
 	}
 	return
 }
 
-func (gt Grant) GetWritable(conn connection.Connection) (tables []database.Table) {
-	for _, t := range gt.AllowWrite {
-		table, err := database.ParseTableName(t, conn.Type)
+// GetRowFilter returns the row filter of the most specific object
+// (exact name, pattern, `schema.*` or `*`) matching the table
+func (gt Grant) GetRowFilter(conn connection.Connection, table database.Table) (predicate string, ok bool) {
+	filters := map[string]string{}
+	for object, predicate := range gt.RowFilter {
+		t, err := database.ParseTableName(object, conn.Type)
 		if err != nil {
-			g.Warn("could not parse table entry: %s", t)
+			g.Warn("could not parse row filter entry: %s", object)
 			continue
 		}
-		tables = append(tables, table)
+		if ObjectMatches(t.FullName(), table) {
+			filters[t.FullName()] = predicate
+		}
 	}
-	return
+
+	objects := lo.Keys(filters)
+	SortObjects(objects)
+	if len(objects) == 0 {
+		return "", false
+	}
+	return filters[objects[len(objects)-1]], true
 }
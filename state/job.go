@@ -0,0 +1,290 @@
+package state
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flarco/g"
+	"github.com/flarco/g/csv"
+	"github.com/samber/lo"
+)
+
+// Job is a query run in the background, its result set being spooled
+// to a gzipped CSV file which can be downloaded until the job expires
+type Job struct {
+	ID       string      `json:"id"`
+	Project  string      `json:"project"`
+	Conn     string      `json:"conn"`
+	Database string      `json:"database,omitempty"`
+	Text     string      `json:"text"`
+	Token    string      `json:"token,omitempty"` // token name of the submitter
+	Status   QueryStatus `json:"status"`
+	Err      string      `json:"err,omitempty"`
+	Columns  []string    `json:"columns,omitempty"`
+	Rows     int64       `json:"rows"`     // spooled so far
+	Bytes    int64       `json:"bytes"`    // compressed size spooled so far
+	Affected int64       `json:"affected"` // -1 for a result set
+	Start    int64       `json:"start"`
+	End      int64       `json:"end,omitempty"`
+	Expires  int64       `json:"expires,omitempty"` // once finished
+	File     string      `json:"-"`
+
+	query     *Query
+	cancelled bool
+	mux       sync.Mutex
+}
+
+// JobTTL is how long the jobs and their results are kept once finished,
+// set with the environment variable DBREST_JOB_TTL (e.g. `48h`)
+var JobTTL = func() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("DBREST_JOB_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}()
+
+// SubmitJob submits the query as a job, without limit. The job is
+// returned right away, and its result set is spooled to the jobs folder.
+func (p *Project) SubmitJob(q *Query) (job *Job, err error) {
+	job = &Job{
+		ID:       q.ID,
+		Project:  p.ID,
+		Conn:     q.Conn,
+		Database: q.Database,
+		Text:     q.Text,
+		Token:    q.Token,
+		Status:   QueryStatusSubmitted,
+		Affected: -1,
+		Start:    time.Now().Unix(),
+		File:     path.Join(p.JobsFolder, q.ID+".csv.gz"),
+		query:    q,
+	}
+
+	if err = os.MkdirAll(p.JobsFolder, 0755); err != nil {
+		return nil, g.Error(err, "could not create jobs folder")
+	}
+
+	q.Limit = -1
+	if _, err = SubmitOrGetQuery(q, false); err != nil {
+		return nil, g.Error(err, "could not submit job query")
+	}
+
+	p.mux.Lock()
+	p.Jobs[job.ID] = job
+	p.mux.Unlock()
+
+	go job.run()
+
+	return job, nil
+}
+
+// GetJob returns the job, from memory or from its saved state
+func (p *Project) GetJob(id string) (job *Job, err error) {
+	p.mux.Lock()
+	job, ok := p.Jobs[id]
+	p.mux.Unlock()
+	if ok {
+		return job, nil
+	}
+
+	// finished before a restart
+	id = path.Base(id)
+	bytes, err := os.ReadFile(path.Join(p.JobsFolder, id+".json"))
+	if err != nil {
+		return nil, g.Error("job %s not found", id)
+	}
+
+	job = &Job{}
+	if err = g.JSONUnmarshal(bytes, job); err != nil {
+		return nil, g.Error(err, "could not parse job %s", id)
+	}
+	job.File = path.Join(p.JobsFolder, id+".csv.gz")
+
+	if job.Expired() {
+		job.Remove(p)
+		return nil, g.Error("job %s not found", id)
+	}
+
+	p.mux.Lock()
+	p.Jobs[job.ID] = job
+	p.mux.Unlock()
+
+	return job, nil
+}
+
+// State returns a copy of the job, with the progress
+func (j *Job) State() *Job {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return &Job{
+		ID:       j.ID,
+		Project:  j.Project,
+		Conn:     j.Conn,
+		Database: j.Database,
+		Text:     j.Text,
+		Token:    j.Token,
+		Status:   j.Status,
+		Err:      j.Err,
+		Columns:  j.Columns,
+		Rows:     atomic.LoadInt64(&j.Rows),
+		Bytes:    atomic.LoadInt64(&j.Bytes),
+		Affected: j.Affected,
+		Start:    j.Start,
+		End:      j.End,
+		Expires:  j.Expires,
+		File:     j.File,
+	}
+}
+
+// Expired returns true if the job finished before the TTL
+func (j *Job) Expired() bool {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.Expires > 0 && time.Now().Unix() > j.Expires
+}
+
+// Cancel cancels the query of a running job
+func (j *Job) Cancel() (err error) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if j.Status != QueryStatusSubmitted || j.query == nil {
+		return g.Error("job %s is not running", j.ID)
+	}
+
+	j.cancelled = true
+	return j.query.Close(true)
+}
+
+// Remove deletes the job and its files
+func (j *Job) Remove(p *Project) {
+	p.mux.Lock()
+	delete(p.Jobs, j.ID)
+	p.mux.Unlock()
+
+	os.Remove(j.File)
+	os.Remove(strings.TrimSuffix(j.File, ".csv.gz") + ".json")
+}
+
+// run waits for the query, spools its result set and saves the job state
+func (j *Job) run() {
+	q := j.query
+	<-q.Done
+
+	var err error
+	if q.Error == nil && q.Stream != nil {
+		j.mux.Lock()
+		j.Columns = q.Stream.Columns.Names()
+		j.mux.Unlock()
+		err = j.spool(q)
+	}
+
+	if perr := q.ProcessResult(); perr != nil {
+		err = perr
+	}
+
+	j.mux.Lock()
+	switch {
+	case j.cancelled, q.Context.Ctx.Err() != nil: // also when cancelled as a query
+		j.Status = QueryStatusCancelled
+		j.Err = ""
+	case err != nil:
+		j.Status = QueryStatusErrored
+		j.Err = g.ErrMsgSimple(err)
+	default:
+		j.Status = QueryStatusCompleted
+		j.Affected = q.Affected
+	}
+	j.End = time.Now().Unix()
+	j.Expires = time.Now().Add(JobTTL).Unix()
+	j.query = nil
+	j.mux.Unlock()
+
+	state := j.State()
+	if state.Status != QueryStatusCompleted || state.Affected != -1 {
+		os.Remove(j.File) // no result set
+	}
+
+	err = os.WriteFile(strings.TrimSuffix(j.File, ".csv.gz")+".json", []byte(g.Marshal(state)), 0600)
+	if err != nil {
+		g.LogError(g.Error(err, "could not save job %s", j.ID))
+	}
+}
+
+// spool writes the rows of the query stream to the gzipped CSV file
+func (j *Job) spool(q *Query) (err error) {
+	file, err := os.OpenFile(j.File, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return g.Error(err, "could not create job file")
+	}
+	defer file.Close()
+
+	gzipW := gzip.NewWriter(&jobCountingWriter{w: file, n: &j.Bytes})
+	csvW := csv.NewWriter(gzipW)
+	if _, err = csvW.Write(q.Stream.Columns.Names()); err != nil {
+		return g.Error(err, "could not write job file")
+	}
+
+	for row := range q.Stream.Rows() {
+		if _, err = csvW.Write(q.Stream.CastRowToString(row)); err != nil {
+			q.Stream.Context.Cancel()
+			return g.Error(err, "could not write job file")
+		}
+		atomic.AddInt64(&j.Rows, 1)
+	}
+
+	csvW.Flush()
+	if err = q.Stream.Err(); err != nil {
+		return g.Error(err, "could not stream job rows")
+	} else if err = csvW.Error(); err != nil {
+		return g.Error(err, "could not write job file")
+	} else if err = gzipW.Close(); err != nil {
+		return g.Error(err, "could not write job file")
+	}
+
+	return nil
+}
+
+// jobCountingWriter counts the bytes written
+type jobCountingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw *jobCountingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	atomic.AddInt64(cw.n, int64(n))
+	return
+}
+
+// ClearExpiredJobs removes the expired jobs of the projects,
+// and their files (also of the jobs saved before a restart)
+func ClearExpiredJobs() {
+	mux.Lock()
+	projects := lo.Values(Projects)
+	mux.Unlock()
+
+	for _, p := range projects {
+		entries, _ := os.ReadDir(p.JobsFolder)
+		for _, entry := range entries {
+			if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+				p.GetJob(id) // removed if expired
+			}
+		}
+
+		p.mux.Lock()
+		jobs := lo.Values(p.Jobs)
+		p.mux.Unlock()
+		for _, job := range jobs {
+			if job.Expired() {
+				job.Remove(p)
+			}
+		}
+	}
+}
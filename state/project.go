@@ -4,12 +4,14 @@ import (
 	"context"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dbrest-io/dbrest/env"
 	"github.com/flarco/g"
+	"github.com/samber/lo"
 	"github.com/slingdata-io/sling-cli/core/dbio/connection"
 	"github.com/slingdata-io/sling-cli/core/dbio/database"
 	"github.com/spf13/cast"
@@ -26,21 +28,27 @@ type Project struct {
 
 	Connections map[string]*Connection
 	Queries     map[string]*Query
+	History     *QueryHistory // nil when disabled
+	Jobs        map[string]*Job
 	Tokens      TokenMap
-	TokenValues map[string]Token
 
 	Roles         RoleMap
+	SavedQueries  SavedQueryMap
 	NoRestriction bool
+	JWT           *JWTConfig // nil when JWT authentication is not configured
 
-	EnvFile   string
-	TokenFile string
-	RolesFile string
+	EnvFile    string
+	TokenFile  string
+	RolesFile  string
+	QueryFile  string // saved queries
+	JobsFolder string
 
 	mux sync.Mutex
 
 	lastLoadedConns  time.Time
 	lastLoadedRoles  time.Time
 	lastLoadedTokens time.Time
+	lastLoadedSaved  time.Time
 }
 
 func DefaultProject() (proj *Project) {
@@ -69,20 +77,27 @@ func NewProject(id, directory string, noRestriction bool) (proj *Project) {
 		Directory:        directory,
 		Connections:      map[string]*Connection{},
 		Queries:          map[string]*Query{},
+		History:          LoadQueryHistory(directory),
+		Jobs:             map[string]*Job{},
 		Tokens:           TokenMap{},
-		TokenValues:      map[string]Token{},
 		Roles:            RoleMap{},
+		SavedQueries:     SavedQueryMap{},
 		NoRestriction:    noRestriction,
+		JWT:              LoadJWTConfig(),
 		EnvFile:          path.Join(directory, "env.yaml"),
 		TokenFile:        path.Join(directory, ".tokens"),
 		RolesFile:        path.Join(directory, "roles.yaml"),
+		QueryFile:        path.Join(directory, "queries.yaml"),
+		JobsFolder:       path.Join(directory, "jobs"),
 		mux:              sync.Mutex{},
 		lastLoadedRoles:  time.Unix(0, 0),
 		lastLoadedTokens: time.Unix(0, 0),
+		lastLoadedSaved:  time.Unix(0, 0),
 	}
 
 	p.LoadTokens(true)
 	p.LoadRoles(true)
+	p.LoadSavedQueries(true)
 	p.LoadConnections(true)
 
 	Projects[id] = p
@@ -97,6 +112,7 @@ func LoadProject(id string) (proj *Project) {
 	if p, ok := Projects[id]; ok {
 		p.LoadTokens(false)
 		p.LoadRoles(false)
+		p.LoadSavedQueries(false)
 		p.LoadConnections(false)
 
 		return p
@@ -131,6 +147,31 @@ func (p *Project) LoadRoles(force bool) (err error) {
 	return
 }
 
+// LoadSavedQueries loads the saved queries file, if present
+func (p *Project) LoadSavedQueries(force bool) (err error) {
+	if !(force || time.Since(p.lastLoadedSaved) > (5*time.Second)) {
+		return
+	}
+
+	if g.PathExists(p.QueryFile) {
+		var queries SavedQueryMap
+		queriesB, _ := os.ReadFile(p.QueryFile)
+		err = yaml.Unmarshal(queriesB, &queries)
+		if err != nil {
+			return g.Error(err, "could not load saved queries")
+		}
+
+		// make names lower case
+		savedQueries := SavedQueryMap{}
+		for name, query := range queries {
+			savedQueries[strings.ToLower(name)] = query
+		}
+		p.SavedQueries = savedQueries
+		p.lastLoadedSaved = time.Now()
+	}
+	return
+}
+
 func (p *Project) GetRoleMap(roles []string) (rm RoleMap) {
 	rm = RoleMap{}
 	for _, rn := range roles {
@@ -226,6 +267,16 @@ func (p *Project) LoadConnections(force bool) (err error) {
 	return nil
 }
 
+// ConnectionNames returns the sorted names of the loaded connections
+func (p *Project) ConnectionNames() (names []string) {
+	p.mux.Lock()
+	names = lo.Keys(p.Connections)
+	p.mux.Unlock()
+
+	sort.Strings(names)
+	return
+}
+
 // SchemaAll returns schema.*
 // notation for all tables in a schema
 func (p *Project) SchemaAll(connection, schema string) (table database.Table) {
@@ -272,6 +323,13 @@ func (p *Project) GetConnInstance(connName, databaseName string) (conn database.
 	}
 	c.Props = conn.Props()
 
+	mux.Lock()
+	if c.instances == nil {
+		c.instances = map[string]database.Connection{}
+	}
+	c.instances[lo.Ternary(databaseName == "", c.DefaultDB(), databaseName)] = conn
+	mux.Unlock()
+
 	// set SetMaxIdleConns
 	// conn.Db().SetMaxIdleConns(2)
 
@@ -287,3 +345,53 @@ func (p *Project) NewQuery(ctx context.Context) *Query {
 	q.Done = make(chan struct{})
 	return q
 }
+
+// CheckFiles parses the roles and tokens files, without loading them.
+// Returns the parse error of each file, nil if valid or missing.
+func (p *Project) CheckFiles() (rolesErr, tokensErr error) {
+	if g.PathExists(p.RolesFile) {
+		var roles RoleMap
+		rolesB, err := os.ReadFile(p.RolesFile)
+		if err == nil {
+			err = yaml.Unmarshal(rolesB, &roles)
+		}
+		if err != nil {
+			rolesErr = g.Error(err, "could not parse roles file")
+		}
+	}
+
+	if g.PathExists(p.TokenFile) {
+		tokens := TokenMap{}
+		bytes, err := os.ReadFile(p.TokenFile)
+		if err == nil {
+			err = g.JSONUnmarshal(bytes, &tokens)
+		}
+		if err != nil {
+			tokensErr = g.Error(err, "could not parse tokens file")
+		}
+	}
+
+	return
+}
+
+// CheckConnection connects to the database and pings it, within the timeout
+func (p *Project) CheckConnection(connName string, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := p.GetConnInstance(connName, "")
+		if err == nil && conn.Db() != nil {
+			err = conn.Db().PingContext(ctx)
+		}
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return g.Error("could not reach connection %s within %s", connName, timeout)
+	}
+}
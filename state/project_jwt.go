@@ -0,0 +1,342 @@
+package state
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flarco/g"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// JWTConfig is the configuration to authenticate with signed JWTs.
+// HS256 tokens are verified with the secret, RS256 / ES256 tokens
+// with the keys of the JSON Web Key Set (file path or URL).
+type JWTConfig struct {
+	Secret     string `json:"-"`
+	JWKS       string `json:"jwks"`
+	RolesClaim string `json:"roles_claim"` // dotted path, e.g. `realm_access.roles`
+	Issuer     string `json:"issuer"`
+	Audience   string `json:"audience"`
+
+	keys      map[string]any // public keys by key ID
+	fetchedAt time.Time
+	fetching  chan struct{} // closed when the key set being fetched is swapped in
+	mux       sync.Mutex
+}
+
+// JWTNamePrefix prefixes the `sub` claim in the token name, so that a
+// JWT cannot own the queries and jobs of an issued token of the same name
+const JWTNamePrefix = "jwt:"
+
+// LoadJWTConfig loads the JWT configuration from the environment variables
+// DBREST_JWT_SECRET, DBREST_JWT_JWKS, DBREST_JWT_ROLES_CLAIM,
+// DBREST_JWT_ISSUER and DBREST_JWT_AUDIENCE. Returns nil if not configured.
+func LoadJWTConfig() *JWTConfig {
+	jc := &JWTConfig{
+		Secret:     os.Getenv("DBREST_JWT_SECRET"),
+		JWKS:       os.Getenv("DBREST_JWT_JWKS"),
+		RolesClaim: os.Getenv("DBREST_JWT_ROLES_CLAIM"),
+		Issuer:     os.Getenv("DBREST_JWT_ISSUER"),
+		Audience:   os.Getenv("DBREST_JWT_AUDIENCE"),
+	}
+	if jc.Secret == "" && jc.JWKS == "" {
+		return nil
+	}
+	jc.RolesClaim = lo.Ternary(jc.RolesClaim == "", "roles", jc.RolesClaim)
+	return jc
+}
+
+// IsJWT returns true if the value has the shape of a JWT
+func IsJWT(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+// ResolveJWT verifies the signed JWT and returns a token with the roles
+// of the roles claim. The other claims are the token attributes.
+func (p *Project) ResolveJWT(value string) (token Token, err error) {
+	if p.JWT == nil {
+		return token, g.Error("JWT authentication is not configured")
+	}
+	return p.JWT.Resolve(value)
+}
+
+// Resolve verifies the signed JWT and returns the corresponding token
+func (jc *JWTConfig) Resolve(value string) (token Token, err error) {
+	methods := []string{}
+	if jc.Secret != "" {
+		methods = append(methods, "HS256")
+	}
+	if jc.JWKS != "" {
+		methods = append(methods, "RS256", "ES256")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if jc.Issuer != "" {
+		options = append(options, jwt.WithIssuer(jc.Issuer))
+	}
+	if jc.Audience != "" {
+		options = append(options, jwt.WithAudience(jc.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.NewParser(options...).ParseWithClaims(value, claims, jc.keyFunc)
+	if err != nil {
+		return token, g.Error(err, "invalid JWT")
+	}
+
+	roles := []string{}
+	if val, ok := claimValue(claims, jc.RolesClaim); ok {
+		roles = claimStrings(val)
+	}
+
+	token = Token{
+		Name:       JWTNamePrefix + cast.ToString(claims["sub"]),
+		Roles:      roles,
+		Attributes: map[string]string{},
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		token.ExpiresAt = &exp.Time
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		token.IssuedAt = iat.Time
+	}
+
+	for key, val := range claims {
+		switch v := val.(type) {
+		case map[string]any:
+			continue
+		case []any:
+			values := claimStrings(v)
+			sort.Strings(values)
+			token.Attributes[key] = strings.Join(values, ",")
+		default:
+			token.Attributes[key] = cast.ToString(v)
+		}
+	}
+
+	return token, nil
+}
+
+// keyFunc returns the key to verify the JWT signature with
+func (jc *JWTConfig) keyFunc(t *jwt.Token) (key any, err error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		return []byte(jc.Secret), nil
+	}
+
+	kid := cast.ToString(t.Header["kid"])
+	key, err = jc.getKey(kid)
+	if err != nil {
+		return nil, g.Error(err, "could not get JWKS key")
+	}
+	return key, nil
+}
+
+// getKey returns the public key of the JWKS. The key set is refreshed every
+// hour, or when the key ID is unknown (at most once a minute). It is fetched
+// outside the lock: meanwhile, the other checks use the cached keys, or wait
+// for the fetch when their key ID is unknown.
+func (jc *JWTConfig) getKey(kid string) (key any, err error) {
+	lookup := func() (any, bool) {
+		if kid == "" && len(jc.keys) == 1 {
+			return lo.Values(jc.keys)[0], true
+		}
+		key, ok := jc.keys[kid]
+		return key, ok
+	}
+
+	jc.mux.Lock()
+	key, ok := lookup()
+	switch {
+	case ok && (time.Since(jc.fetchedAt) < time.Hour || jc.fetching != nil):
+		jc.mux.Unlock()
+		return key, nil
+	case jc.fetching != nil:
+		fetching := jc.fetching
+		jc.mux.Unlock()
+		<-fetching
+
+		jc.mux.Lock()
+		key, ok = lookup()
+		jc.mux.Unlock()
+		if !ok {
+			return nil, g.Error("unknown key ID: %s", kid)
+		}
+		return key, nil
+	case !ok && time.Since(jc.fetchedAt) < time.Minute:
+		jc.mux.Unlock()
+		return nil, g.Error("unknown key ID: %s", kid)
+	}
+	fetching := make(chan struct{})
+	jc.fetching = fetching
+	jc.mux.Unlock()
+
+	keys, err := loadJWKS(jc.JWKS)
+
+	jc.mux.Lock()
+	jc.fetchedAt = time.Now()
+	if err == nil {
+		jc.keys = keys
+	}
+	jc.fetching = nil
+	close(fetching)
+	cachedKey, cached := key, ok
+	key, ok = lookup()
+	jc.mux.Unlock()
+
+	if err != nil {
+		if cached {
+			g.Warn("could not refresh JWKS, using cached keys: %s", err.Error())
+			return cachedKey, nil
+		}
+		return nil, err
+	} else if !ok {
+		return nil, g.Error("unknown key ID: %s", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the JSON Web Key Set from a file path or URL
+func loadJWKS(location string) (keys map[string]any, err error) {
+	var body []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, g.Error(err, "could not fetch JWKS from %s", location)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, g.Error("could not fetch JWKS from %s: status %d", location, resp.StatusCode)
+		}
+
+		body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, g.Error(err, "could not read JWKS from %s", location)
+		}
+	} else {
+		body, err = os.ReadFile(location)
+		if err != nil {
+			return nil, g.Error(err, "could not read JWKS file %s", location)
+		}
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err = g.JSONUnmarshal(body, &jwks); err != nil {
+		return nil, g.Error(err, "could not parse JWKS")
+	}
+
+	keys = map[string]any{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			g.Warn("skipping JWKS key %s: %s", jwk.Kid, err.Error())
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// publicKey returns the RSA or ECDSA public key
+func (jwk jsonWebKey) publicKey() (key any, err error) {
+	decode := func(value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, g.Error(err, "invalid modulus")
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, g.Error(err, "invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, g.Error("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, g.Error(err, "invalid x coordinate")
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, g.Error(err, "invalid y coordinate")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, g.Error("unsupported key type: %s", jwk.Kty)
+}
+
+// claimValue returns the value of a dotted claim path
+func claimValue(claims map[string]any, path string) (val any, ok bool) {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		if val, ok = claims[part]; !ok {
+			return nil, false
+		} else if i < len(parts)-1 {
+			if claims, ok = val.(map[string]any); !ok {
+				return nil, false
+			}
+		}
+	}
+	return
+}
+
+// claimStrings returns the values of an array claim, or of a
+// comma / space separated string claim
+func claimStrings(val any) (values []string) {
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			values = append(values, cast.ToString(item))
+		}
+	case string:
+		values = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return
+}
@@ -1,6 +1,11 @@
 package state
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math/big"
 	"os"
 	"strings"
 	"time"
@@ -13,10 +18,57 @@ import (
 type TokenMap map[string]Token
 
 type Token struct {
-	Token    string    `json:"token"`
-	Roles    []string  `json:"roles"`
-	Disabled bool      `json:"disabled"`
-	IssuedAt time.Time `json:"issued_at"`
+	Name       string            `json:"-"`               // set when resolved
+	Token      string            `json:"token,omitempty"` // plaintext, only set when issued
+	Hash       string            `json:"hash"`            // salted SHA-256 hash of the token
+	Salt       string            `json:"salt"`
+	Roles      []string          `json:"roles"`
+	Disabled   bool              `json:"disabled"`
+	IssuedAt   time.Time         `json:"issued_at"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"` // for row filters
+}
+
+// Matches returns true if the value is the token, comparing
+// the hashes in constant time
+func (t Token) Matches(value string) bool {
+	if t.Hash == "" || value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(value, t.Salt)), []byte(t.Hash)) == 1
+}
+
+// Expired returns true if the token has an expiry in the past
+func (t Token) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Valid returns true if the token is enabled and not expired
+func (t Token) Valid() bool {
+	return !t.Disabled && !t.Expired()
+}
+
+// randomString returns an alphanumeric string from crypto/rand,
+// for token values and salts
+func randomString(length int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	max := big.NewInt(int64(len(chars)))
+
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(g.Error(err, "could not generate random string"))
+		}
+		b[i] = chars[n.Int64()]
+	}
+	return string(b)
+}
+
+// hashToken returns the salted SHA-256 hash of a token value
+func hashToken(value, salt string) string {
+	hash := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(hash[:])
 }
 
 func (p *Project) LoadTokens(force bool) (err error) {
@@ -25,18 +77,39 @@ func (p *Project) LoadTokens(force bool) (err error) {
 	}
 
 	if !g.PathExists(p.TokenFile) {
-		os.WriteFile(p.TokenFile, []byte("{}"), 0644)
+		os.WriteFile(p.TokenFile, []byte("{}"), 0600)
 	}
 
+	tokens := TokenMap{}
 	bytes, _ := os.ReadFile(p.TokenFile)
-	err = g.JSONUnmarshal(bytes, &p.Tokens)
+	err = g.JSONUnmarshal(bytes, &tokens)
 	if err != nil {
-		err = g.Error(err, "could not unmarshal token map")
+		// keep the loaded tokens until the file is fixed
+		p.lastLoadedTokens = time.Now()
+		return g.Error(err, "could not unmarshal token map")
+	}
+
+	// migrate plaintext tokens from previous versions
+	migrated := 0
+	for name, token := range tokens {
+		if token.Hash == "" && token.Token != "" {
+			token.Salt = randomString(16)
+			token.Hash = hashToken(token.Token, token.Salt)
+			token.Token = ""
+			tokens[name] = token
+			migrated++
+		}
 	}
 
-	// populate token values map
-	for _, token := range p.Tokens {
-		p.TokenValues[token.Token] = token
+	p.mux.Lock()
+	p.Tokens = tokens
+	p.mux.Unlock()
+
+	if migrated > 0 {
+		if err = p.TokenSave(); err != nil {
+			return g.Error(err, "could not migrate plaintext tokens")
+		}
+		g.Info("migrated %d plaintext tokens to hashed tokens in %s", migrated, p.TokenFile)
 	}
 
 	p.lastLoadedTokens = time.Now()
@@ -44,10 +117,18 @@ func (p *Project) LoadTokens(force bool) (err error) {
 	return
 }
 
+// ResolveToken returns the token matching the value. All tokens are
+// compared, so that the duration does not depend on the match.
 func (p *Project) ResolveToken(value string) (token Token, ok bool) {
 	p.mux.Lock()
-	token, ok = p.TokenValues[value]
-	p.mux.Unlock()
+	defer p.mux.Unlock()
+
+	for name, t := range p.Tokens {
+		if t.Matches(value) {
+			token, ok = t, true
+			token.Name = name
+		}
+	}
 	return
 }
 
@@ -78,9 +159,10 @@ func (p *Project) TokenAdd(name string, token Token) (err error) {
 		}
 	}
 
+	token.Token = "" // only the hash is stored
+
 	p.mux.Lock()
 	p.Tokens[name] = token
-	p.TokenValues[token.Token] = token
 	p.mux.Unlock()
 
 	err = p.TokenSave()
@@ -95,13 +177,13 @@ func (p *Project) TokenToggle(name string) (disabled bool, err error) {
 	p.mux.Lock()
 	token, ok := p.Tokens[name]
 	if !ok {
+		p.mux.Unlock()
 		return disabled, g.Error("token %s does not exist", name)
 	}
 
 	token.Disabled = !token.Disabled
 	disabled = token.Disabled
 	p.Tokens[name] = token
-	p.TokenValues[token.Token] = token
 	p.mux.Unlock()
 
 	err = p.TokenSave()
@@ -114,13 +196,12 @@ func (p *Project) TokenToggle(name string) (disabled bool, err error) {
 
 func (p *Project) TokenRemove(name string) (err error) {
 	p.mux.Lock()
-	token, ok := p.Tokens[name]
-	if !ok {
+	if _, ok := p.Tokens[name]; !ok {
+		p.mux.Unlock()
 		return g.Error("token %s does not exist", name)
 	}
 
 	delete(p.Tokens, name)
-	delete(p.TokenValues, token.Token)
 	p.mux.Unlock()
 
 	err = p.TokenSave()
@@ -133,16 +214,22 @@ func (p *Project) TokenRemove(name string) (err error) {
 func (p *Project) TokenSave() (err error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	err = os.WriteFile(p.TokenFile, []byte(g.Marshal(p.Tokens)), 0644)
+	err = os.WriteFile(p.TokenFile, []byte(g.Marshal(p.Tokens)), 0600)
 	if err != nil {
 		err = g.Error(err, "could not write token map")
 	}
 	return
 }
 
+// NewToken creates a token with a random value. The plaintext value
+// is only available on the returned token, to be shown once.
 func NewToken(roles []string) Token {
+	value := randomString(64)
+	salt := randomString(16)
 	return Token{
-		Token:    g.RandString(g.AlphaNumericRunes, 64),
+		Token:    value,
+		Hash:     hashToken(value, salt),
+		Salt:     salt,
 		Roles:    roles,
 		Disabled: false,
 		IssuedAt: time.Now(),
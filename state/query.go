@@ -1,12 +1,15 @@
 package state
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"strings"
 	"time"
 
 	"github.com/flarco/g"
 	"github.com/jmoiron/sqlx"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio"
 	"github.com/slingdata-io/sling-cli/core/dbio/database"
 	"github.com/slingdata-io/sling-cli/core/dbio/iop"
 	"gopkg.in/yaml.v3"
@@ -19,22 +22,28 @@ type Query struct {
 	Conn     string `json:"conn" query:"conn" gorm:"index"`
 	Database string `json:"database" query:"database" gorm:"index"`
 	Text     string `json:"text" query:"text"`
+	Args     []any  `json:"-" query:"-" gorm:"-"`         // bind values for the text
 	Limit    int    `json:"limit" query:"limit" gorm:"-"` // -1 is unlimited
+	ReadOnly bool   `json:"-" query:"-" gorm:"-"`         // run in a read-only transaction, where supported
+	Saved    string `json:"-" query:"-" gorm:"-"`         // name of the saved query, if any
 
 	Start   int64       `json:"start" query:"start" gorm:"index:idx_start"`
 	End     int64       `json:"end" query:"end"`
 	Status  QueryStatus `json:"status" query:"status"`
 	Err     string      `json:"err" query:"err"`
 	Headers Headers     `json:"headers" query:"headers" gorm:"headers"`
+	Token   string      `json:"token,omitempty" query:"token" gorm:"index"` // token name of the submitter
 
 	UpdatedDt   time.Time           `json:"-" gorm:"autoUpdateTime"`
 	Connection  database.Connection `json:"-" gorm:"-"`
 	Affected    int64               `json:"affected" gorm:"-"`
+	Columns     iop.Columns         `json:"-" gorm:"-"` // known column types, when binding
 	Result      *sqlx.Rows          `json:"-" gorm:"-"`
 	Stream      *iop.Datastream     `json:"-" gorm:"-"`
 	Done        chan struct{}       `json:"-" gorm:"-"`
 	Error       error               `json:"-" gorm:"-"`
 	Context     *g.Context          `json:"-" gorm:"-"`
+	readOnlyTx  *sqlx.Tx            `json:"-" gorm:"-"`
 	lastTouch   time.Time           `json:"-" gorm:"-"`
 	IsGenerated bool                `json:"-" gorm:"-"`
 }
@@ -95,6 +104,7 @@ func SubmitOrGetQuery(q *Query, cont bool) (query *Query, err error) {
 		mux.Lock()
 		var ok bool
 		query, ok = proj.Queries[q.ID]
+		ok = ok && query.Conn == q.Conn
 		if ok {
 			query.lastTouch = time.Now()
 		}
@@ -140,39 +150,58 @@ func (q *Query) Cancel() (err error) {
 		return
 	}
 
-	q.Status = QueryStatusCancelled
+	q.setStatus(QueryStatusCancelled)
+	q.End = time.Now().Unix()
 
 	mux.Lock()
 	delete(proj.Queries, q.ID)
 	mux.Unlock()
 
+	q.record()
+
 	return
 }
 
 func (q *Query) Submit() (err error) {
 	defer func() { q.Done <- struct{}{} }()
+	defer q.record()
 
 	setError := func(err error) {
-		q.Status = QueryStatusErrored
+		q.setStatus(QueryStatusErrored)
 		q.Error = err
 		q.Err = g.ErrMsg(err)
 		q.End = time.Now().Unix()
 	}
 
+	mux.Lock()
 	q.Status = QueryStatusSubmitted
+	q.Start = time.Now().Unix()
+	mux.Unlock()
 	q.Context = g.NewContext(q.Connection.Context().Ctx)
+	q.record()
 
 	sqls := database.ParseSQLMultiStatements(q.Text)
-	if len(sqls) == 1 && q.isSelecting() {
+	selecting := len(sqls) == 1 && q.isSelecting()
+	if q.ReadOnly && !selecting {
+		err = g.Error("only a single select statement is allowed")
+		setError(err)
+		return err
+	}
+
+	if selecting {
 		g.Debug("--------------------------------------------------------------------- submitting %s (selecting)", q.ID)
-		q.Stream, err = q.Connection.StreamRowsContext(q.Context.Ctx, q.Text, g.M("limit", q.Limit))
+		if len(q.Args) > 0 || (q.ReadOnly && q.supportsReadOnlyTx()) {
+			q.Stream, err = q.streamRowsWithArgs()
+		} else {
+			q.Stream, err = q.Connection.StreamRowsContext(q.Context.Ctx, q.Text, g.M("limit", q.Limit))
+		}
 		if err != nil {
 			setError(err)
 			err = g.Error(err, "could not execute query")
 			return
 		}
 
-		q.Status = QueryStatusCompleted
+		q.setStatus(QueryStatusCompleted)
 	} else {
 		g.Debug("--------------------------------------------------------------------- submitting %s (executing)", q.ID)
 		_, err = q.Connection.NewTransaction(q.Context.Ctx)
@@ -183,7 +212,12 @@ func (q *Query) Submit() (err error) {
 		}
 
 		defer q.Connection.Rollback()
-		res, err := q.Connection.ExecMultiContext(q.Context.Ctx, q.Text)
+		var res sql.Result
+		if len(q.Args) > 0 {
+			res, err = q.Connection.ExecContext(q.Context.Ctx, q.Text, q.Args...)
+		} else {
+			res, err = q.Connection.ExecMultiContext(q.Context.Ctx, q.Text)
+		}
 		if err != nil {
 			setError(err)
 			err = g.Error(err, "could not execute queries")
@@ -196,13 +230,124 @@ func (q *Query) Submit() (err error) {
 			return err
 		}
 
-		q.Status = QueryStatusCompleted
+		q.setStatus(QueryStatusCompleted)
 		q.Affected, _ = res.RowsAffected()
 	}
 
 	return
 }
 
+// setStatus sets the status under the lock, as it is read
+// by QueryCounts and ActiveQueries while the query runs
+func (q *Query) setStatus(status QueryStatus) {
+	mux.Lock()
+	q.Status = status
+	mux.Unlock()
+}
+
+// supportsReadOnlyTx returns true if the database enforces read-only
+// transactions, so that a ReadOnly query cannot write even through a
+// function with side effects
+func (q *Query) supportsReadOnlyTx() bool {
+	if q.Connection.Db() == nil {
+		return false
+	}
+
+	switch q.Connection.GetType() {
+	case dbio.TypeDbPostgres, dbio.TypeDbRedshift, dbio.TypeDbMySQL, dbio.TypeDbMariaDB:
+		return true
+	}
+	return false
+}
+
+// streamRowsWithArgs streams the query rows with the bind values, in a
+// read-only transaction if q.ReadOnly (see supportsReadOnlyTx).
+// The column types are taken from q.Columns when known.
+func (q *Query) streamRowsWithArgs() (ds *iop.Datastream, err error) {
+	if q.Connection.Db() == nil {
+		return nil, g.Error("connection %s does not support bind values", q.Conn)
+	}
+
+	var result *sqlx.Rows
+	if q.ReadOnly && q.supportsReadOnlyTx() {
+		q.readOnlyTx, err = q.Connection.Db().BeginTxx(q.Context.Ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, g.Error(err, "could not begin read-only transaction")
+		}
+		result, err = q.readOnlyTx.QueryxContext(q.Context.Ctx, q.Text, q.Args...)
+	} else {
+		result, err = q.Connection.Db().QueryxContext(q.Context.Ctx, q.Text, q.Args...)
+	}
+	if err != nil {
+		q.rollbackReadOnly()
+		return nil, g.Error(err, "could not execute query")
+	}
+	q.Result = result
+
+	fields, err := result.Columns()
+	if err != nil {
+		result.Close()
+		q.rollbackReadOnly()
+		return nil, g.Error(err, "could not get result columns")
+	}
+
+	columns := iop.Columns{}
+	for i, field := range fields {
+		col := iop.Column{Name: field, Position: i + 1, Type: iop.StringType}
+		if known := q.Columns.GetColumn(field); known != nil {
+			col.Type = known.Type
+			col.DbType = known.DbType
+		}
+		columns = append(columns, col)
+	}
+
+	limit := uint64(0) // infinite
+	if q.Limit > 0 {
+		limit = uint64(q.Limit)
+	}
+
+	nextFunc := func(it *iop.Iterator) bool {
+		if limit > 0 && it.Counter >= limit {
+			result.Close()
+			q.rollbackReadOnly()
+			return false
+		}
+
+		if result.Next() {
+			row, err := result.SliceScan()
+			if err != nil {
+				it.Context.CaptureErr(g.Error(err, "could not scan row"))
+				return false
+			}
+			it.Row = row
+			return true
+		}
+
+		result.Close()
+		if err := result.Err(); err != nil {
+			it.Context.CaptureErr(g.Error(err, "error during iteration"))
+		}
+		q.rollbackReadOnly()
+		return false
+	}
+
+	ds = iop.NewDatastreamIt(q.Context.Ctx, columns, nextFunc)
+	ds.Inferred = len(q.Columns) > 0
+	err = ds.Start()
+	if err != nil {
+		return ds, g.Error(err, "could not start datastream")
+	}
+
+	return
+}
+
+// rollbackReadOnly ends the read-only transaction, if any
+func (q *Query) rollbackReadOnly() {
+	if q.readOnlyTx != nil {
+		q.readOnlyTx.Rollback()
+	}
+}
+
 // processCustomReq looks at the text for yaml parsing
 func (q *Query) prepare() (err error) {
 
@@ -225,8 +370,14 @@ func (q *Query) prepare() (err error) {
 	}
 
 	mux.Lock()
-	proj.Queries[q.ID] = q
+	_, exists := proj.Queries[q.ID]
+	if !exists {
+		proj.Queries[q.ID] = q
+	}
 	mux.Unlock()
+	if exists {
+		return g.Error("query id %s is already in use", q.ID)
+	}
 
 	q.Text = strings.TrimSuffix(q.Text, ";")
 
@@ -279,30 +430,16 @@ func (q *Query) processCustomReq() (err error) {
 
 // isSelecting detects whether a query is a SELECT query
 func (q *Query) isSelecting() bool {
-	// Parse the SQL statements
-	sqls := database.ParseSQLMultiStatements(q.Text)
-
-	// Check each statement
-	for _, sql := range sqls {
-		if t, err := database.TrimSQLComments(sql); err == nil {
-			sql = t
-		}
-		normalizedSQL := strings.ToLower(strings.TrimSpace(sql))
-		if strings.HasPrefix(normalizedSQL, "create") || strings.HasPrefix(normalizedSQL, "insert") ||
-			strings.HasPrefix(normalizedSQL, "update") || strings.HasPrefix(normalizedSQL, "delete") ||
-			strings.HasPrefix(normalizedSQL, "drop") || strings.HasPrefix(normalizedSQL, "alter") ||
-			strings.HasPrefix(normalizedSQL, "truncate") || strings.HasPrefix(normalizedSQL, "merge") ||
-			strings.HasPrefix(normalizedSQL, "grant") || strings.HasPrefix(normalizedSQL, "revoke") {
+	class := ClassifySQL(q.Text)
+	for _, kind := range class.Kinds {
+		if lo.Contains(sqlWriteKinds, kind) {
 			return false
-		}
-		if strings.HasPrefix(normalizedSQL, "select") ||
-			strings.HasPrefix(normalizedSQL, "with") ||
-			(strings.Contains(normalizedSQL, "select") && strings.Contains(normalizedSQL, "from")) {
+		} else if lo.Contains(sqlReadOnlyKinds, kind) {
 			return true
 		}
 	}
 
-	return false
+	return class.Selects
 }
 
 // Close closes and cancels the query
@@ -316,6 +453,7 @@ func (q *Query) Close(cancel bool) (err error) {
 			return g.Error(err, "could not close results")
 		}
 	}
+	q.rollbackReadOnly()
 	return
 }
 
@@ -344,6 +482,18 @@ func (q *Query) ProcessResult() (err error) {
 	q.Close(false)
 
 	q.End = time.Now().Unix()
+	q.record()
 
 	return
 }
+
+// record persists the query in the history of its project, if enabled
+func (q *Query) record() {
+	mux.Lock()
+	proj := Projects[q.Project]
+	mux.Unlock()
+
+	if proj != nil {
+		proj.History.Record(q)
+	}
+}
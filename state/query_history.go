@@ -0,0 +1,342 @@
+package state
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
+	"github.com/spf13/cast"
+)
+
+// QueryHistory persists the metadata of the queries of a project
+// (not their results) in an embedded SQLite database
+type QueryHistory struct {
+	File      string
+	Retention time.Duration // records older than this are pruned
+
+	conn    database.Connection
+	pruned  time.Time
+	rows    chan queryHistoryRow // queued records
+	flushes chan chan struct{}   // flush requests, closed when done
+	mux     sync.Mutex
+}
+
+// queryHistoryMaxLimit is the maximum number of queries listed at once
+const queryHistoryMaxLimit = 1000
+
+// queryHistoryDDL creates the history table and its indexes
+var queryHistoryDDL = []string{
+	`create table if not exists query_history (
+		id text primary key,
+		project text not null,
+		conn text not null,
+		"database" text,
+		text text,
+		start integer not null,
+		"end" integer,
+		status text not null,
+		err text,
+		affected integer,
+		token text
+	)`,
+	`create index if not exists idx_query_history_start on query_history (start)`,
+	`create index if not exists idx_query_history_status on query_history (status, start)`,
+}
+
+// queryHistoryRow is a row of the history table
+type queryHistoryRow struct {
+	ID       string `db:"id"`
+	Project  string `db:"project"`
+	Conn     string `db:"conn"`
+	Database string `db:"database"`
+	Text     string `db:"text"`
+	Start    int64  `db:"start"`
+	End      int64  `db:"end"`
+	Status   string `db:"status"`
+	Err      string `db:"err"`
+	Affected int64  `db:"affected"`
+	Token    string `db:"token"`
+}
+
+// QueryHistoryFilter selects the queries of the history
+type QueryHistoryFilter struct {
+	Conn   string
+	Token  string        // only the queries of the token, when set
+	Status []QueryStatus // any status when empty
+	Since  time.Time     // start, inclusive
+	Until  time.Time     // start, exclusive
+	Limit  int
+}
+
+// LoadQueryHistory returns the query history stored in the directory,
+// configured with the environment variables DBREST_QUERY_HISTORY (set to
+// false to disable) and DBREST_QUERY_HISTORY_DAYS (retention, default 30).
+// Returns nil if disabled.
+func LoadQueryHistory(directory string) *QueryHistory {
+	if val := os.Getenv("DBREST_QUERY_HISTORY"); val != "" && !cast.ToBool(val) {
+		return nil
+	}
+
+	days := cast.ToInt(os.Getenv("DBREST_QUERY_HISTORY_DAYS"))
+	if days <= 0 {
+		days = 30
+	}
+
+	qh := &QueryHistory{
+		File:      path.Join(directory, ".history.db"),
+		Retention: time.Duration(days) * 24 * time.Hour,
+		rows:      make(chan queryHistoryRow, 10000),
+		flushes:   make(chan chan struct{}),
+	}
+	go qh.loopWrite()
+
+	return qh
+}
+
+// open connects to the database and creates the table, once
+func (qh *QueryHistory) open() (conn database.Connection, err error) {
+	if qh.conn != nil {
+		return qh.conn, nil
+	}
+
+	if err = os.MkdirAll(path.Dir(qh.File), 0755); err != nil {
+		return nil, g.Error(err, "could not create query history folder")
+	}
+
+	file, err := filepath.Abs(qh.File)
+	if err != nil {
+		return nil, g.Error(err, "could not resolve query history path")
+	}
+
+	conn, err = database.NewConn("sqlite://" + file)
+	if err != nil {
+		return nil, g.Error(err, "could not initialize query history database")
+	} else if err = conn.Connect(); err != nil {
+		return nil, g.Error(err, "could not connect to query history database")
+	}
+
+	for _, sql := range queryHistoryDDL {
+		if _, err = conn.Db().Exec(sql); err != nil {
+			conn.Close()
+			return nil, g.Error(err, "could not create query history table")
+		}
+	}
+
+	qh.conn = conn
+	return conn, nil
+}
+
+// Record queues the query to be inserted or updated. Records are
+// written asynchronously (in batches), see Flush.
+func (qh *QueryHistory) Record(q *Query) {
+	if qh == nil {
+		return
+	}
+
+	row := queryHistoryRow{
+		ID:       q.ID,
+		Project:  q.Project,
+		Conn:     q.Conn,
+		Database: q.Database,
+		Text:     q.Text,
+		Start:    q.Start,
+		End:      q.End,
+		Status:   string(q.Status),
+		Err:      q.Err,
+		Affected: q.Affected,
+		Token:    q.Token,
+	}
+
+	select {
+	case qh.rows <- row:
+	default:
+		g.Warn("query history queue is full, query %s not recorded", q.ID)
+	}
+}
+
+// Flush waits until the queued records are written
+func (qh *QueryHistory) Flush() {
+	if qh == nil {
+		return
+	}
+
+	flushed := make(chan struct{})
+	qh.flushes <- flushed
+	<-flushed
+}
+
+// loopWrite writes the queued records, in batches
+func (qh *QueryHistory) loopWrite() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := []queryHistoryRow{}
+	for {
+		var flushed chan struct{}
+		select {
+		case row := <-qh.rows:
+			batch = append(batch, row)
+			if len(batch) < 500 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case flushed = <-qh.flushes:
+			for drained := false; !drained; {
+				select {
+				case row := <-qh.rows:
+					batch = append(batch, row)
+				default:
+					drained = true
+				}
+			}
+		}
+
+		if len(batch) > 0 {
+			if err := qh.write(batch); err != nil {
+				g.LogError(g.Error(err, "could not record %d queries", len(batch)))
+			}
+			batch = []queryHistoryRow{}
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
+
+// write inserts or updates the records in a transaction,
+// and prunes the old ones hourly
+func (qh *QueryHistory) write(rows []queryHistoryRow) (err error) {
+	qh.mux.Lock()
+	defer qh.mux.Unlock()
+
+	conn, err := qh.open()
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Db().Beginx()
+	if err != nil {
+		return g.Error(err, "could not begin query history transaction")
+	}
+	defer tx.Rollback()
+
+	sql := `insert or replace into query_history
+		(id, project, conn, "database", text, start, "end", status, err, affected, token)
+		values (:id, :project, :conn, :database, :text, :start, :end, :status, :err, :affected, :token)`
+	for _, row := range rows {
+		if _, err = tx.NamedExec(sql, row); err != nil {
+			return g.Error(err, "could not record query %s", row.ID)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return g.Error(err, "could not commit query history")
+	}
+
+	// prune old records, hourly
+	if time.Since(qh.pruned) > time.Hour {
+		qh.pruned = time.Now()
+		oldest := time.Now().Add(-qh.Retention).Unix()
+		if _, err = conn.Db().Exec(`delete from query_history where start < ?`, oldest); err != nil {
+			return g.Error(err, "could not prune query history")
+		}
+	}
+
+	return nil
+}
+
+// List returns the queries matching the filter, latest first
+func (qh *QueryHistory) List(project string, filter QueryHistoryFilter) (queries []Query, err error) {
+	if qh == nil {
+		return nil, g.Error("query history is disabled")
+	}
+
+	qh.Flush() // include the queued records
+
+	qh.mux.Lock()
+	defer qh.mux.Unlock()
+
+	conn, err := qh.open()
+	if err != nil {
+		return nil, err
+	}
+
+	wheres := []string{"project = ?", "conn = ?"}
+	args := []any{project, filter.Conn}
+	if filter.Token != "" {
+		wheres = append(wheres, "token = ?")
+		args = append(args, filter.Token)
+	}
+	if len(filter.Status) > 0 {
+		placeholders := lo.Map(filter.Status, func(status QueryStatus, i int) string { return "?" })
+		wheres = append(wheres, g.F("status in (%s)", strings.Join(placeholders, ", ")))
+		for _, status := range filter.Status {
+			args = append(args, string(status))
+		}
+	}
+	if !filter.Since.IsZero() {
+		wheres = append(wheres, "start >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		wheres = append(wheres, "start < ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	limit := lo.Ternary(filter.Limit > 0, min(filter.Limit, queryHistoryMaxLimit), 100)
+	sql := g.F(
+		`select id, project, conn, coalesce("database", '') as "database", coalesce(text, '') as text,
+			start, coalesce("end", 0) as "end", status, coalesce(err, '') as err,
+			coalesce(affected, -1) as affected, coalesce(token, '') as token
+		from query_history where %s order by start desc, id desc limit %d`,
+		strings.Join(wheres, " and "), limit,
+	)
+
+	rows := []queryHistoryRow{}
+	if err = conn.Db().Select(&rows, sql, args...); err != nil {
+		return nil, g.Error(err, "could not read query history")
+	}
+
+	for _, row := range rows {
+		queries = append(queries, Query{
+			ID:       row.ID,
+			Project:  row.Project,
+			Conn:     row.Conn,
+			Database: row.Database,
+			Text:     row.Text,
+			Start:    row.Start,
+			End:      row.End,
+			Status:   QueryStatus(row.Status),
+			Err:      row.Err,
+			Affected: row.Affected,
+			Token:    row.Token,
+		})
+	}
+
+	return queries, nil
+}
+
+// Close writes the queued records and closes the history database
+func (qh *QueryHistory) Close() {
+	if qh == nil {
+		return
+	}
+
+	qh.Flush()
+
+	qh.mux.Lock()
+	defer qh.mux.Unlock()
+	if qh.conn != nil {
+		qh.conn.Close()
+		qh.conn = nil
+	}
+}
@@ -0,0 +1,525 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+)
+
+// SQLClassification is the result of the classification of a SQL text
+type SQLClassification struct {
+	Kinds    []string `json:"kinds"`            // first keyword of each statement
+	Selects  bool     `json:"selects"`          // has a select with a from clause
+	ReadOnly bool     `json:"read_only"`        // proven to be read-only
+	Reason   string   `json:"reason,omitempty"` // why it is not read-only
+	Tables   []string `json:"tables"`           // referenced tables, as written
+}
+
+var (
+	// sqlReadOnlyKinds are the statement kinds which can be read-only
+	sqlReadOnlyKinds = []string{"select", "with", "values"}
+
+	// sqlWriteKinds are the statement kinds which write
+	sqlWriteKinds = []string{
+		"create", "insert", "update", "delete", "drop", "alter",
+		"truncate", "merge", "grant", "revoke",
+	}
+
+	// sqlWriteKeywords are not allowed anywhere in a read-only statement,
+	// since some databases do not require a separator between statements
+	sqlWriteKeywords = []string{
+		"insert", "update", "delete", "merge", "create", "drop", "alter",
+		"truncate", "grant", "revoke", "deny", "into", "exec", "execute",
+		"lock", "set", "use", "kill", "shutdown", "dbcc", "backup", "restore",
+		"bulk", "waitfor",
+	}
+
+	// sqlWriteFunctions are write keywords which are also function names
+	sqlWriteFunctions = []string{"insert"}
+
+	// sqlSafeFunctions are the functions allowed in a read-only statement.
+	// Any other function may have side effects or read data bypassing the
+	// table grants (files, other databases, sql text, security definer).
+	sqlSafeFunctions = []string{
+		// aggregates
+		"count", "sum", "avg", "min", "max", "stddev", "stddev_pop", "stddev_samp",
+		"variance", "var_pop", "var_samp", "string_agg", "array_agg", "group_concat",
+		"listagg", "bool_and", "bool_or", "every", "json_agg", "jsonb_agg",
+		"json_object_agg", "jsonb_object_agg", "percentile_cont", "percentile_disc",
+		"mode", "median", "approx_count_distinct", "corr", "covar_pop", "covar_samp",
+		// window
+		"row_number", "rank", "dense_rank", "percent_rank", "cume_dist", "ntile",
+		"lag", "lead", "first_value", "last_value", "nth_value",
+		// conditional and conversion
+		"coalesce", "nullif", "greatest", "least", "ifnull", "isnull", "nvl", "nvl2",
+		"iif", "if", "cast", "try_cast", "convert", "try_convert", "typeof",
+		// numeric
+		"abs", "ceil", "ceiling", "floor", "round", "trunc", "truncate", "mod",
+		"power", "pow", "sqrt", "exp", "ln", "log", "log10", "sign", "random", "rand",
+		// string
+		"lower", "upper", "length", "char_length", "character_length", "octet_length",
+		"len", "substr", "substring", "trim", "ltrim", "rtrim", "btrim", "replace",
+		"concat", "concat_ws", "left", "right", "lpad", "rpad", "position", "strpos",
+		"instr", "charindex", "reverse", "repeat", "split_part", "initcap", "chr",
+		"char", "ascii", "hex", "md5", "regexp_replace", "regexp_like", "regexp_substr",
+		"overlay", "insert",
+		// date and time
+		"now", "extract", "date_trunc", "date_part", "datepart", "datediff",
+		"dateadd", "date_add", "date_sub", "date_format", "to_char", "to_date",
+		"to_timestamp", "to_number", "date", "datetime", "strftime", "julianday",
+		"year", "month", "day", "hour", "minute", "second", "age", "make_date",
+		// json
+		"json_extract", "json_extract_path", "json_extract_path_text",
+		"jsonb_extract_path", "jsonb_extract_path_text", "json_value", "json_query",
+		"json_array_length", "jsonb_array_length", "json_build_object",
+		"jsonb_build_object", "json_build_array", "jsonb_build_array", "to_json",
+		"to_jsonb", "json_object", "json_array",
+	}
+
+	// sqlCallKeywords are keywords and type names which can be followed
+	// by a parenthesis, without being function calls
+	sqlCallKeywords = []string{
+		"select", "from", "join", "in", "exists", "as", "over", "values", "using",
+		"on", "and", "or", "not", "where", "when", "then", "else", "any", "all",
+		"some", "filter", "group", "within", "partition", "by", "with", "is",
+		"between", "case", "lateral", "union", "except", "intersect", "distinct",
+		"cube", "rollup", "grouping", "sets", "row", "array", "like", "escape",
+		"limit", "offset", "top", "materialized", "apply", "straight_join",
+		// types
+		"varchar", "nvarchar", "nchar", "character", "varying", "numeric", "decimal",
+		"number", "float", "double", "real", "int", "integer", "bigint", "smallint",
+		"timestamp", "timestamptz", "time", "interval", "datetime2", "datetimeoffset",
+		"varbinary", "binary", "bit",
+	}
+
+	// sqlFromFunctions use `from` as an argument separator
+	sqlFromFunctions = []string{"extract", "substring", "trim", "overlay"}
+
+	// sqlJoinKeywords are followed by a table reference. `table name` is
+	// a select of the table (PostgreSQL, MySQL), also in a sub-query
+	sqlJoinKeywords = []string{"from", "join", "straight_join", "apply", "table"}
+
+	// sqlFromEndKeywords end the table references of a from clause
+	sqlFromEndKeywords = []string{
+		"where", "group", "order", "having", "window", "limit", "offset",
+		"fetch", "for", "union", "except", "intersect", "minus", "qualify",
+		"connect", "start",
+	}
+
+	// sqlClauseKeywords follow a table reference, and cannot be aliases
+	sqlClauseKeywords = []string{
+		"where", "join", "inner", "left", "right", "full", "cross", "natural",
+		"outer", "on", "using", "group", "order", "having", "window", "limit",
+		"offset", "fetch", "for", "union", "except", "intersect", "minus",
+		"qualify", "tablesample", "pivot", "unpivot", "with", "straight_join",
+		"lateral", "as", "apply", "connect", "start", "sample",
+	}
+)
+
+// ClassifySQL classifies the statements of a SQL text. To be read-only,
+// every statement must be a select (`select`, `with` or `values`), without
+// write keywords, with only the allowed unqualified functions (see
+// sqlSafeFunctions), and with only tables as references
+// in the from clauses (no table functions or files). The text is tokenized
+// twice, with standard quoting and with backslash escapes (MySQL), so that
+// quoting differences between databases cannot hide a statement.
+func ClassifySQL(text string) (class SQLClassification) {
+	standard := classifySQLTokens(tokenizeSQL(text, false))
+	backslash := classifySQLTokens(tokenizeSQL(text, true))
+
+	class = standard
+	class.ReadOnly = standard.ReadOnly && backslash.ReadOnly
+	class.Reason = lo.Ternary(standard.Reason != "", standard.Reason, backslash.Reason)
+	class.Tables = lo.Uniq(append(standard.Tables, backslash.Tables...))
+	return
+}
+
+type sqlTokenKind int
+
+const (
+	sqlTokenWord    sqlTokenKind = iota // keyword or unquoted identifier
+	sqlTokenQuoted                      // quoted identifier
+	sqlTokenString                      // string literal
+	sqlTokenSymbol                      // operator or punctuation
+	sqlTokenInvalid                     // construct which cannot be classified
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string // lower case for words, unquoted for identifiers
+	raw  string
+	pos  int // byte offset of raw in the text
+}
+
+func (t sqlToken) is(kind sqlTokenKind, texts ...string) bool {
+	return t.kind == kind && (len(texts) == 0 || lo.Contains(texts, t.text))
+}
+
+// tokenizeSQL splits the text into tokens, skipping the comments.
+// With backslash, quotes are escaped with backslashes and `#` starts
+// a comment (MySQL), otherwise dollar-quoting (PostgreSQL) and
+// q-quoting (Oracle) are recognized.
+func tokenizeSQL(text string, backslash bool) (tokens []sqlToken) {
+	isWordChar := func(c byte) bool {
+		return c == '_' || c == '$' || c == '@' || c >= 0x80 ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			(c == '#' && !backslash)
+	}
+
+	// readQuoted returns the end index after the closing quote
+	readQuoted := func(i int, closing byte) (end int, ok bool) {
+		for j := i + 1; j < len(text); j++ {
+			switch {
+			case backslash && text[j] == '\\':
+				j++
+			case text[j] == closing && j+1 < len(text) && text[j+1] == closing:
+				j++ // doubled quote
+			case text[j] == closing:
+				return j + 1, true
+			}
+		}
+		return len(text), false
+	}
+
+	invalid := func(pos int, raw string) {
+		tokens = append(tokens, sqlToken{kind: sqlTokenInvalid, text: raw, raw: raw, pos: pos})
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(text[i:], "--") || (backslash && c == '#'):
+			end := strings.IndexByte(text[i:], '\n')
+			i = lo.Ternary(end == -1, len(text), i+end+1)
+		case strings.HasPrefix(text[i:], "/*"):
+			if backslash && strings.HasPrefix(text[i:], "/*!") {
+				invalid(i, "/*!") // executable comment
+			}
+			end := strings.Index(text[i+2:], "*/")
+			if end == -1 {
+				invalid(i, "/*")
+				i = len(text)
+			} else {
+				i = i + 2 + end + 2
+			}
+		case c == '\'':
+			end, ok := readQuoted(i, '\'')
+			if !ok {
+				invalid(i, text[i:end])
+			}
+			tokens = append(tokens, sqlToken{kind: sqlTokenString, text: text[i:end], raw: text[i:end], pos: i})
+			i = end
+		case c == '"' || c == '`':
+			end, ok := readQuoted(i, c)
+			if !ok {
+				invalid(i, text[i:end])
+			}
+			name := strings.Trim(text[i:end], string(c))
+			tokens = append(tokens, sqlToken{kind: sqlTokenQuoted, text: strings.ToLower(name), raw: text[i:end], pos: i})
+			i = end
+		case c == '$' && !backslash && dollarTag(text[i:]) != "":
+			tag := dollarTag(text[i:])
+			end := strings.Index(text[i+len(tag):], tag)
+			if end == -1 {
+				invalid(i, text[i:])
+				i = len(text)
+			} else {
+				end = i + len(tag) + end + len(tag)
+				tokens = append(tokens, sqlToken{kind: sqlTokenString, text: text[i:end], raw: text[i:end], pos: i})
+				i = end
+			}
+		case isWordChar(c):
+			j := i
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+			word := strings.ToLower(text[i:j])
+
+			// oracle q-quoting, e.g. q'[it's]'
+			if !backslash && (word == "q" || word == "nq") && j+1 < len(text) && text[j] == '\'' {
+				closing := text[j+1]
+				switch closing {
+				case '[':
+					closing = ']'
+				case '(':
+					closing = ')'
+				case '{':
+					closing = '}'
+				case '<':
+					closing = '>'
+				}
+				end := strings.Index(text[j+2:], string(closing)+"'")
+				if end == -1 {
+					invalid(i, text[i:])
+					i = len(text)
+				} else {
+					end = j + 2 + end + 2
+					tokens = append(tokens, sqlToken{kind: sqlTokenString, text: text[i:end], raw: text[i:end], pos: i})
+					i = end
+				}
+				continue
+			}
+
+			tokens = append(tokens, sqlToken{kind: sqlTokenWord, text: word, raw: text[i:j], pos: i})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{kind: sqlTokenSymbol, text: string(c), raw: string(c), pos: i})
+			i++
+		}
+	}
+	return
+}
+
+// dollarTag returns the dollar-quote tag at the start of text (e.g. `$$`
+// or `$body$`), or an empty string
+func dollarTag(text string) string {
+	for j := 1; j < len(text); j++ {
+		c := text[j]
+		switch {
+		case c == '$':
+			return text[:j+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (j > 1 && c >= '0' && c <= '9'):
+			continue
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// classifySQLTokens classifies the tokens of a SQL text
+func classifySQLTokens(tokens []sqlToken) (class SQLClassification) {
+	class.ReadOnly = true
+	notReadOnly := func(format string, args ...any) {
+		if class.ReadOnly {
+			class.ReadOnly = false
+			class.Reason = g.F(format, args...)
+		}
+	}
+
+	// matching parenthesis indexes
+	matching := map[int]int{}
+	stack := []int{}
+	for i, token := range tokens {
+		switch {
+		case token.is(sqlTokenSymbol, "("):
+			stack = append(stack, i)
+		case token.is(sqlTokenSymbol, ")"):
+			if len(stack) == 0 {
+				notReadOnly("unbalanced parenthesis")
+				continue
+			}
+			matching[stack[len(stack)-1]] = i
+			matching[i] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) > 0 {
+		notReadOnly("unbalanced parenthesis")
+	}
+
+	// common table expression names, e.g. `name as (select ...)`
+	cteNames := []string{}
+	for i, token := range tokens {
+		if !token.is(sqlTokenWord, "as") {
+			continue
+		}
+
+		j := i + 1
+		for j < len(tokens) && tokens[j].is(sqlTokenWord, "not", "materialized") {
+			j++
+		}
+		if j+1 >= len(tokens) || !tokens[j].is(sqlTokenSymbol, "(") || !tokens[j+1].is(sqlTokenWord, sqlReadOnlyKinds...) {
+			continue
+		}
+
+		k := i - 1
+		if k >= 0 && tokens[k].is(sqlTokenSymbol, ")") {
+			k = matching[k] - 1 // column list
+		}
+		if k >= 0 && (tokens[k].kind == sqlTokenWord || tokens[k].kind == sqlTokenQuoted) {
+			cteNames = append(cteNames, tokens[k].text)
+		}
+	}
+
+	// aliases with a column list, e.g. `t as a(x, y)`, are not calls
+	aliasLists := map[int]bool{}
+
+	// readAlias reads the alias of a table reference at index j, with
+	// optional column list, returns the index after it
+	readAlias := func(j int) int {
+		if j < len(tokens) && tokens[j].is(sqlTokenWord, "as") {
+			j++
+		}
+		if j < len(tokens) && (tokens[j].kind == sqlTokenQuoted ||
+			(tokens[j].kind == sqlTokenWord && !lo.Contains(sqlClauseKeywords, tokens[j].text))) {
+			j++
+			if j < len(tokens) && tokens[j].is(sqlTokenSymbol, "(") {
+				aliasLists[j-1] = true
+				j = matching[j] + 1
+			}
+		}
+		return j
+	}
+
+	// readReference reads a table reference at index j, returns the index after it
+	readReference := func(j int) int {
+		for j < len(tokens) && tokens[j].is(sqlTokenWord, "only", "lateral") {
+			j++
+		}
+		if j >= len(tokens) {
+			return j
+		}
+
+		switch token := tokens[j]; {
+		case token.is(sqlTokenSymbol, "("):
+			return readAlias(matching[j] + 1) // sub-query, scanned on its own
+		case token.kind == sqlTokenString:
+			notReadOnly("file or string reference in from clause: %s", token.raw)
+			return j + 1
+		case token.kind != sqlTokenWord && token.kind != sqlTokenQuoted && !token.is(sqlTokenSymbol, "["):
+			return j
+		}
+
+		// dotted name, with `[bracket]` identifiers (SQL Server)
+		parts := []string{}
+		quoted := false
+		for j < len(tokens) {
+			if token := tokens[j]; token.is(sqlTokenSymbol, "[") {
+				k := j + 1
+				for k < len(tokens) && !tokens[k].is(sqlTokenSymbol, "]") {
+					k++
+				}
+				raws := lo.Map(tokens[j+1:k], func(t sqlToken, i int) string { return t.raw })
+				parts = append(parts, `"`+strings.Join(raws, " ")+`"`)
+				quoted = true
+				j = k + 1
+			} else if token.kind == sqlTokenWord || token.kind == sqlTokenQuoted {
+				parts = append(parts, token.raw)
+				quoted = quoted || token.kind == sqlTokenQuoted
+				j++
+			} else {
+				break
+			}
+
+			if j+1 >= len(tokens) || !tokens[j].is(sqlTokenSymbol, ".") {
+				break
+			}
+			j++
+		}
+
+		name := strings.Join(parts, ".")
+		switch {
+		case j < len(tokens) && tokens[j].is(sqlTokenSymbol, "("):
+			notReadOnly("function in from clause: %s", name)
+			return matching[j] + 1
+		case len(parts) == 1 && !quoted && lo.Contains(cteNames, strings.ToLower(name)):
+			// common table expression
+		default:
+			class.Tables = append(class.Tables, name)
+		}
+
+		return readAlias(j)
+	}
+
+	// unsafeCall returns true if the name at index i, followed by a
+	// parenthesis, is a function call which is not allowed
+	unsafeCall := func(i int) bool {
+		token := tokens[i]
+		switch {
+		case token.kind != sqlTokenWord && token.kind != sqlTokenQuoted:
+			return false
+		case aliasLists[i]:
+			return false
+		case lo.Contains(cteNames, token.text):
+			// common table expression column list, e.g. `name(a, b) as (...)`
+			end := matching[i+1] + 1
+			if end < len(tokens) && tokens[end].is(sqlTokenWord, "as") {
+				return false
+			}
+		}
+
+		if i > 0 && tokens[i-1].is(sqlTokenSymbol, ".") {
+			return true // qualified, may not be the built-in function
+		} else if token.kind == sqlTokenQuoted {
+			return true
+		}
+		return !lo.Contains(sqlSafeFunctions, token.text) && !lo.Contains(sqlCallKeywords, token.text)
+	}
+
+	hasSelect := lo.ContainsBy(tokens, func(t sqlToken) bool { return t.is(sqlTokenWord, "select") })
+
+	// functions owning each open parenthesis
+	functions := []string{}
+	statementStart := true
+
+	// parenthesis depths within the table references of a from clause,
+	// where a comma (also after a join condition) is followed by a reference
+	inFrom := map[int]bool{}
+	for i, token := range tokens {
+		var next sqlToken
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+
+		if statementStart && token.kind == sqlTokenWord {
+			class.Kinds = append(class.Kinds, token.text)
+			if !lo.Contains(sqlReadOnlyKinds, token.text) {
+				notReadOnly("statement `%s` is not a select", token.text)
+			}
+			statementStart = false
+		}
+
+		if token.is(sqlTokenWord, sqlFromEndKeywords...) {
+			delete(inFrom, len(functions)) // end of the table references
+		}
+
+		isCall := next.is(sqlTokenSymbol, "(")
+		switch {
+		case token.kind == sqlTokenInvalid:
+			notReadOnly("cannot parse `%s`", token.raw)
+		case token.is(sqlTokenSymbol, ";"):
+			statementStart = true
+			inFrom = map[int]bool{}
+		case token.is(sqlTokenSymbol, "("):
+			function := ""
+			if i > 0 && (tokens[i-1].kind == sqlTokenWord || tokens[i-1].kind == sqlTokenQuoted) {
+				function = tokens[i-1].text
+			}
+			functions = append(functions, function)
+		case token.is(sqlTokenSymbol, ")"):
+			delete(inFrom, len(functions))
+			if len(functions) > 0 {
+				functions = functions[:len(functions)-1]
+			}
+		case token.is(sqlTokenSymbol, ",") && inFrom[len(functions)]:
+			readReference(i + 1)
+		case token.kind == sqlTokenWord && lo.Contains(sqlWriteKeywords, token.text) &&
+			!(isCall && lo.Contains(sqlWriteFunctions, token.text)):
+			notReadOnly("keyword `%s` is not allowed", token.text)
+		case isCall && unsafeCall(i):
+			notReadOnly("function `%s` is not allowed", token.text)
+		case token.is(sqlTokenWord, sqlJoinKeywords...):
+			if token.text == "from" {
+				if len(functions) > 0 && lo.Contains(sqlFromFunctions, functions[len(functions)-1]) {
+					continue // e.g. extract(year from col)
+				} else if i > 0 && tokens[i-1].is(sqlTokenWord, "distinct") {
+					continue // is distinct from
+				}
+				class.Selects = hasSelect
+				inFrom[len(functions)] = true
+			}
+
+			readReference(i + 1)
+		}
+	}
+
+	if len(class.Kinds) == 0 {
+		notReadOnly("no statement")
+	}
+
+	return
+}
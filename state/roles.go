@@ -1,18 +1,22 @@
 package state
 
 import (
+	"sort"
 	"strings"
 
+	"github.com/samber/lo"
 	"github.com/slingdata-io/sling-cli/core/dbio/connection"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
 )
 
 var (
 	AllowAllRoleMap = RoleMap{
 		"*": Role{
 			"*": Grant{
-				AllowRead:  []string{"*"},
-				AllowWrite: []string{"*"},
-				AllowSQL:   AllowSQLAny,
+				AllowRead:    []string{"*"},
+				AllowWrite:   []string{"*"},
+				AllowExecute: []string{"*"},
+				AllowSQL:     AllowSQLAny,
 			},
 		},
 	}
@@ -48,19 +52,40 @@ func (rm RoleMap) GetPermissions(conn connection.Connection) (perms Permissions)
 		}
 
 		if ok {
-			tables := grant.GetReadable(conn)
-			for _, table := range tables {
-				perms[table.FullName()] = PermissionRead
+			for permission, entries := range grant.Entries() {
+				tables, _, denies := parseGrantEntries(entries, conn)
+				for i, table := range tables {
+					perms[lo.Ternary(denies[i], "!", "")+table.FullName()] |= permission
+				}
 			}
+		}
+	}
+
+	return
+}
 
-			tables = grant.GetWritable(conn)
-			for _, table := range tables {
-				if p, ok := perms[table.FullName()]; ok {
-					if p == PermissionRead {
-						perms[table.FullName()] = PermissionReadWrite
+// GetColumnPermissions returns the column rules of the grants for the connection
+func (rm RoleMap) GetColumnPermissions(conn connection.Connection) (perms ColumnPermissions) {
+	perms = ColumnPermissions{}
+	for _, op := range Operations {
+		perms[op] = ColumnRules{}
+	}
+
+	for _, role := range rm {
+		grant, ok := role[strings.ToLower(conn.Name)]
+		if !ok {
+			grant, ok = role["*"]
+		}
+
+		if ok {
+			for permission, entries := range grant.Entries() {
+				tables, rules, denies := parseGrantEntries(entries, conn)
+				for _, op := range permission.Operations() {
+					for i, table := range tables {
+						if !denies[i] {
+							perms[op][table.FullName()] = append(perms[op][table.FullName()], rules[i])
+						}
 					}
-				} else {
-					perms[table.FullName()] = PermissionWrite
 				}
 			}
 		}
@@ -69,6 +94,36 @@ func (rm RoleMap) GetPermissions(conn connection.Connection) (perms Permissions)
 	return
 }
 
+// GetRowFilters returns the row filter predicates for the table.
+// Only roles granting the operation on the table are considered.
+// If any of those roles has no row filter, the rows are unrestricted
+// and no predicates are returned.
+func (rm RoleMap) GetRowFilters(conn connection.Connection, table database.Table, op Operation) (predicates []string) {
+	for name, role := range rm {
+		grant, ok := role[strings.ToLower(conn.Name)]
+		if !ok {
+			grant, ok = role["*"]
+		}
+		if !ok {
+			continue
+		}
+
+		perms := RoleMap{name: role}.GetPermissions(conn)
+		if !perms.Get(table).Can(op) {
+			continue
+		}
+
+		predicate, ok := grant.GetRowFilter(conn, table)
+		if !ok || strings.TrimSpace(predicate) == "" {
+			return nil // unrestricted
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	sort.Strings(predicates) // deterministic SQL
+	return lo.Uniq(predicates)
+}
+
 func (rm RoleMap) CanSQL(connection string) bool {
 	for _, role := range rm {
 		if ok := role.CanSQL(connection); ok {
@@ -86,3 +141,23 @@ func (r Role) CanSQL(connection string) bool {
 	}
 	return false
 }
+
+// CanSelectSQL returns true if read-only SQL can be submitted
+// (`allow_sql: only_select` or `any`)
+func (rm RoleMap) CanSelectSQL(connection string) bool {
+	for _, role := range rm {
+		if ok := role.CanSelectSQL(connection); ok {
+			return ok
+		}
+	}
+	return false
+}
+
+func (r Role) CanSelectSQL(connection string) bool {
+	if grant, ok := r[connection]; ok {
+		return grant.AllowSQL == AllowSQLAny || grant.AllowSQL == AllowSQLOnlySelect
+	} else if grant, ok := r["*"]; ok {
+		return grant.AllowSQL == AllowSQLAny || grant.AllowSQL == AllowSQLOnlySelect
+	}
+	return false
+}
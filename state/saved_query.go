@@ -0,0 +1,141 @@
+package state
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/flarco/g"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// SavedQuery is a named SQL template of the queries file, run with
+// typed parameters by the allowed roles
+type SavedQuery struct {
+	// Description is shown to the users
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// SQL is the query text, with parameters referenced as `{name}`
+	SQL string `json:"sql" yaml:"sql"`
+	// Params are the parameters by name
+	Params map[string]SavedQueryParam `json:"params,omitempty" yaml:"params,omitempty"`
+	// Roles lists the roles allowed to run the query
+	Roles []string `json:"roles" yaml:"roles"`
+	// Connections lists the connections the query runs on, all if empty
+	Connections []string `json:"connections,omitempty" yaml:"connections,omitempty"`
+}
+
+// SavedQueryParam is a typed parameter of a saved query
+type SavedQueryParam struct {
+	// Type is one of `string` (default), `integer`, `number`, `boolean`,
+	// `date` or `timestamp`
+	Type     string `json:"type,omitempty" yaml:"type,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default  any    `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// SavedQueryMap is a map of saved queries
+// each map key is a query name
+type SavedQueryMap map[string]SavedQuery
+
+// savedQueryParamRegex matches the name of a `{<param>}` placeholder
+var savedQueryParamRegex = regexp.MustCompile(`^\w+$`)
+
+// Allowed returns true if one of the roles may run the query.
+// A `*` entry allows all roles.
+func (sq SavedQuery) Allowed(roles RoleMap) bool {
+	if _, ok := roles["*"]; ok {
+		return true // no restriction
+	}
+	return lo.SomeBy(sq.Roles, func(role string) bool {
+		_, ok := roles[strings.ToLower(role)]
+		return ok || (role == "*" && len(roles) > 0)
+	})
+}
+
+// AllowedConnection returns true if the query runs on the connection
+func (sq SavedQuery) AllowedConnection(connection string) bool {
+	return len(sq.Connections) == 0 || lo.SomeBy(sq.Connections, func(name string) bool {
+		return strings.EqualFold(name, connection)
+	})
+}
+
+// Render replaces the parameters of the SQL with the placeholders returned
+// by bind, for the typed values. Values are strings (from a query string)
+// or decoded JSON values.
+func (sq SavedQuery) Render(values map[string]any, bind func(value any) string) (sql string, err error) {
+	for name := range values {
+		if _, ok := sq.Params[name]; !ok {
+			return "", g.Error("unknown parameter: %s", name)
+		}
+	}
+
+	typed := map[string]any{}
+	for name, param := range sq.Params {
+		value, ok := values[name]
+		if !ok || value == nil {
+			if param.Required {
+				return "", g.Error("missing required parameter: %s", name)
+			}
+			value = param.Default
+		}
+
+		if typed[name], err = param.Parse(value); err != nil {
+			return "", g.Error(err, "invalid value for parameter %s", name)
+		}
+	}
+
+	// placeholders are the `{`, name and `}` tokens, so that braces in
+	// string literals (e.g. '\d{3}'), quoted identifiers or comments are kept
+	var sb strings.Builder
+	last := 0
+	tokens := tokenizeSQL(sq.SQL, false)
+	for i := 0; i+2 < len(tokens); i++ {
+		open, name, closing := tokens[i], tokens[i+1], tokens[i+2]
+		if !open.is(sqlTokenSymbol, "{") || !name.is(sqlTokenWord) || !closing.is(sqlTokenSymbol, "}") ||
+			name.pos != open.pos+1 || closing.pos != name.pos+len(name.raw) ||
+			!savedQueryParamRegex.MatchString(name.raw) {
+			continue
+		}
+
+		value, ok := typed[name.raw]
+		if !ok {
+			return "", g.Error("parameter `%s` is not declared", name.raw)
+		}
+		sb.WriteString(sq.SQL[last:open.pos])
+		sb.WriteString(bind(value))
+		last = closing.pos + 1
+		i += 2
+	}
+	sb.WriteString(sq.SQL[last:])
+
+	return sb.String(), nil
+}
+
+// Parse converts the value to the type of the parameter
+func (p SavedQueryParam) Parse(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch strings.ToLower(p.Type) {
+	case "", "string":
+		return cast.ToStringE(value)
+	case "integer":
+		return cast.ToInt64E(strings.TrimSpace(cast.ToString(value)))
+	case "number":
+		return cast.ToFloat64E(strings.TrimSpace(cast.ToString(value)))
+	case "boolean":
+		return cast.ToBoolE(strings.TrimSpace(cast.ToString(value)))
+	case "date", "timestamp":
+		t, err := cast.ToTimeE(value)
+		if err != nil {
+			return nil, err
+		} else if strings.EqualFold(p.Type, "date") {
+			return t.Format(time.DateOnly), nil
+		}
+		return t.Format(time.DateTime), nil
+	}
+
+	return nil, g.Error("invalid parameter type: %s", p.Type)
+}
@@ -1,11 +1,14 @@
 package state
 
 import (
+	"database/sql"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/flarco/g"
 	"github.com/slingdata-io/sling-cli/core/dbio/connection"
+	"github.com/slingdata-io/sling-cli/core/dbio/database"
 )
 
 var (
@@ -25,6 +28,8 @@ type Connection struct {
 	Conn   connection.Connection
 	Source string
 	Props  map[string]string // to cache vars
+
+	instances map[string]database.Connection // by database, for pool stats
 }
 
 // DefaultDB returns the default database
@@ -41,9 +46,84 @@ func CloseConnections() {
 			g.LogError(c.Conn.Close())
 			delete(p.Connections, k)
 		}
+		p.History.Close()
+		p.mux.Unlock()
+	}
+	mux.Unlock()
+}
+
+// QueryCounts returns the number of queries per project and status
+func QueryCounts() (counts map[string]map[QueryStatus]int) {
+	counts = map[string]map[QueryStatus]int{}
+	mux.Lock()
+	for _, p := range Projects {
+		counts[p.ID] = map[QueryStatus]int{}
+		for _, q := range p.Queries {
+			counts[p.ID][q.Status]++
+		}
+	}
+	mux.Unlock()
+	return
+}
+
+// ActiveQueries returns a copy of the in-flight queries of the project
+// (identification and progress only), oldest first
+func (p *Project) ActiveQueries() (queries []*Query) {
+	mux.Lock()
+	for _, q := range p.Queries {
+		queries = append(queries, &Query{
+			ID:       q.ID,
+			Project:  q.Project,
+			Conn:     q.Conn,
+			Database: q.Database,
+			Text:     q.Text,
+			Saved:    q.Saved,
+			Start:    q.Start,
+			Status:   q.Status,
+			Token:    q.Token,
+		})
+	}
+	mux.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Start == queries[j].Start {
+			return queries[i].ID < queries[j].ID
+		}
+		return queries[i].Start < queries[j].Start
+	})
+	return
+}
+
+// ConnectionStat is the connection pool stats of a database connection
+type ConnectionStat struct {
+	Project    string
+	Connection string
+	Database   string
+	Stats      sql.DBStats
+}
+
+// ConnectionStats returns the pool stats of the connections used so far
+func ConnectionStats() (stats []ConnectionStat) {
+	mux.Lock()
+	for _, p := range Projects {
+		p.mux.Lock()
+		for name, c := range p.Connections {
+			for dbName, conn := range c.instances {
+				if conn.Db() == nil {
+					continue // not a database/sql connection
+				}
+				stats = append(stats, ConnectionStat{
+					Project:    p.ID,
+					Connection: name,
+					Database:   dbName,
+					Stats:      conn.Db().Stats(),
+				})
+			}
+		}
 		p.mux.Unlock()
 	}
 	mux.Unlock()
+	return
 }
 
 func ClearOldQueries() {
@@ -71,6 +151,7 @@ func loop() {
 		case <-ticker1Min.C:
 		case <-ticker10Min.C:
 			go ClearOldQueries()
+			go ClearExpiredJobs()
 		}
 	}
 }